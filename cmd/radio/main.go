@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -38,6 +40,59 @@ func main() {
 
 	// Audio pipeline
 	pipeline := audio.NewPipeline(cfg.CrossfadeDuration)
+	pipeline.SetLoudnessTarget(cfg.TargetLUFS, cfg.TruePeakCeiling)
+	pipeline.SetBeatSync(cfg.BeatSync)
+	pipeline.SetHarmonicMix(cfg.HarmonicMix)
+	pipeline.SetSilenceTrim(audio.SilenceConfig{
+		ThresholdDB: cfg.SilenceThreshold,
+		MinDuration: cfg.SilenceMinDuration,
+	})
+	monitorCfg := pipeline.Monitor().Config()
+	monitorCfg.SilenceDB, monitorCfg.SilenceTimeout = cfg.DeadAirThreshold, cfg.DeadAirTimeout
+	pipeline.Monitor().SetConfig(monitorCfg)
+	if mode, err := audio.ParseStandbyMode(cfg.StandbyMode); err != nil {
+		log.Printf("Invalid RADIO_STANDBY, using %s: %v", pipeline.Standby().Mode, err)
+	} else {
+		pipeline.SetStandby(audio.StandbyConfig{Mode: mode, File: cfg.StandbyFile})
+	}
+	if spec, err := audio.ParseTransition(cfg.TransitionStyle + ":" + cfg.TransitionCurve); err != nil {
+		log.Printf("Invalid transition config, using %s: %v", audio.DefaultTransition, err)
+	} else {
+		pipeline.SetTransition(spec)
+	}
+	if rules, err := audio.ParseTransitionRules(cfg.TransitionRules); err != nil {
+		log.Printf("Invalid RADIO_TRANSITION_RULES, ignoring: %v", err)
+	} else if len(rules) > 0 {
+		pipeline.SetTransitionRules(rules)
+	}
+	pipeline.SetJingleDir(cfg.JingleDir)
+	if rules, err := audio.ParseJingleRules(cfg.JingleRules); err != nil {
+		log.Printf("Invalid RADIO_JINGLE_RULES, ignoring: %v", err)
+	} else {
+		pipeline.SetJingleRules(rules)
+	}
+	pipeline.Ambient().SetDir(cfg.AmbientDir)
+	if cfg.TTSCommand != "" {
+		if tts, err := audio.NewExecTTS(cfg.TTSCommand); err != nil {
+			log.Printf("Invalid RADIO_TTS_COMMAND, ignoring: %v", err)
+		} else {
+			pipeline.SetTTS(tts)
+		}
+	}
+	pipeline.SetVoiceDir(cfg.VoiceDir)
+	pipeline.SetVoiceOver(cfg.VoiceOver)
+	if state, err := config.LoadState(cfg.StateFile); err != nil {
+		log.Printf("Invalid state file %s, using defaults: %v", cfg.StateFile, err)
+	} else {
+		pipeline.SetMasterGain(state.MasterGain)
+		pipeline.SetMute(state.Muted)
+	}
+	saveState := func() {
+		state := config.State{MasterGain: pipeline.MasterGain(), Muted: pipeline.Muted()}
+		if err := config.SaveState(cfg.StateFile, state); err != nil {
+			log.Printf("Save state: %v", err)
+		}
+	}
 	go pipeline.Run(ctx)
 
 	// Broadcaster: fan-out PCM frames to all listeners
@@ -79,12 +134,62 @@ func main() {
 		log.Println("Ollama not configured (set OLLAMA_URL to enable LLM captions)")
 	}
 
-	// WebRTC handler (track peer count for status)
+	// Stream handlers (track listener counts for status)
+	httpCfg := stream.HTTPConfig{Mode: stream.EncoderShared, Bitrate: 192}
+	if mode, err := stream.ParseEncoderMode(cfg.HTTPEncoder); err != nil {
+		log.Printf("Invalid RADIO_HTTP_ENCODER, using %s: %v", httpCfg.Mode, err)
+	} else {
+		httpCfg.Mode = mode
+	}
+	if stream.ValidMP3Bitrate(cfg.MP3Bitrate) {
+		httpCfg.Bitrate = cfg.MP3Bitrate
+	} else {
+		log.Printf("Invalid RADIO_MP3_BITRATE %d, using %dk", cfg.MP3Bitrate, httpCfg.Bitrate)
+	}
+	nowPlaying := func() stream.NowPlaying {
+		track := pipeline.OnAir()
+		return stream.NowPlaying{Title: track.Name, Genre: track.Genre}
+	}
+	encoders := stream.NewEncoders(broadcaster)
+	httpHandler := stream.NewHTTPHandler(encoders, httpCfg, nowPlaying)
 	webrtcHandler := stream.NewWebRTCHandler(broadcaster)
+	hlsCfg := stream.HLSConfig{Codec: cfg.HLSCodec, Bitrate: cfg.HLSBitrate, Segment: cfg.HLSSegment, Window: cfg.HLSWindow}
+	hlsHandler, err := stream.NewHLSHandler(encoders, hlsCfg, nowPlaying)
+	if err != nil {
+		log.Printf("Invalid HLS config, using aac 128k, 6s x 6: %v", err)
+		hlsCfg = stream.HLSConfig{Codec: "aac", Bitrate: 128, Segment: 6 * time.Second, Window: 6}
+		hlsHandler, _ = stream.NewHLSHandler(encoders, hlsCfg, nowPlaying)
+	}
 
-	// Idle detection: pause generation when nobody is listening
+	// Icecast source client (optional -- publishes the station to an Icecast server)
+	var icecast *stream.IcecastSource
+	if cfg.IcecastURL != "" {
+		icecast, err = stream.NewIcecastSource(encoders, stream.IcecastConfig{
+			URL:      cfg.IcecastURL,
+			Mount:    cfg.IcecastMount,
+			User:     cfg.IcecastUser,
+			Password: cfg.IcecastPassword,
+			Protocol: cfg.IcecastProtocol,
+			Format:   cfg.IcecastFormat,
+			Bitrate:  cfg.IcecastBitrate,
+			Name:     "infinara",
+			Public:   cfg.IcecastPublic,
+		}, nowPlaying)
+		if err != nil {
+			log.Printf("Icecast disabled: %v", err)
+		} else {
+			go icecast.Run(ctx)
+		}
+	}
+
+	// Idle detection: pause generation when nobody is listening. A connected
+	// Icecast source counts as a listener, since its audience is unknown here.
 	sched.SetListenerCountFunc(func() int {
-		return broadcaster.ListenerCount() + webrtcHandler.PeerCount()
+		n := httpHandler.ListenerCount() + hlsHandler.ListenerCount() + webrtcHandler.PeerCount()
+		if icecast != nil && icecast.Connected() {
+			n++
+		}
+		return n
 	})
 
 	go sched.Run(ctx)
@@ -103,13 +208,19 @@ func main() {
 	})
 
 	// Audio streams
-	mux.Handle("/stream", stream.NewHTTPHandler(broadcaster))
+	for _, mount := range stream.HTTPMounts() {
+		mux.Handle(mount, httpHandler)
+	}
+	mux.Handle("/hls/", hlsHandler)
 	mux.Handle("/offer", webrtcHandler)
 
 	// API endpoints
 	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
 		djStatus := sched.Status()
 		track, pos, dur := pipeline.Status()
+		targetLUFS, peakCeiling := pipeline.LoudnessTarget()
+		clock := pipeline.ClockStats()
+		monitor := pipeline.Monitor().Stats()
 
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -128,21 +239,61 @@ func main() {
 			"track_id":         track.ID,
 			"track_name":       trackName,
 			"track_path":       track.Path,
+			"loudness":         track.Loudness,
+			"true_peak":        track.TruePeak,
+			"gain":             track.Gain,
+			"bpm":              track.BPM,
+			"key":              track.Key.String(),
+			"camelot":          track.Key.Camelot(),
+			"centroid":         track.Centroid,
+			"energy":           track.Energy,
+			"trim_start":       track.TrimStart.Seconds(),
+			"trim_end":         track.TrimEnd.Seconds(),
+			"standby":          pipeline.InStandby(),
+			"paused":           pipeline.Paused(),
+			"interstitial":     track.Interstitial,
 			"position":         pos.Seconds(),
 			"duration":         dur.Seconds(),
 			"caption":          sched.LastCaption(),
 			"lyrics":           sched.LastLyrics(),
-			"http_listeners":   broadcaster.ListenerCount(),
+			"http_listeners":   httpHandler.ListenerCount(),
+			"hls_listeners":    hlsHandler.ListenerCount(),
 			"webrtc_listeners": webrtcHandler.PeerCount(),
+			"icecast":          icecast != nil && icecast.Connected(),
+			"clock": map[string]any{
+				"frames":       clock.Frames,
+				"late_frames":  clock.Late,
+				"resyncs":      clock.Resyncs,
+				"last_late_ms": clock.LastLate.Milliseconds(),
+				"max_late_ms":  clock.MaxLate.Milliseconds(),
+			},
+			"monitor": map[string]any{
+				"rms":         monitor.RMS,
+				"peak":        monitor.Peak,
+				"clip_ratio":  monitor.ClipRatio,
+				"dead_air":    monitor.DeadAir,
+				"clip_events": monitor.ClipEvents,
+			},
 			"config": map[string]any{
-				"model":           "acestep-v15-base",
-				"inference_steps": cfg.InferenceSteps,
-				"guidance_scale":  cfg.GuidanceScale,
-				"shift":           cfg.Shift,
-				"audio_format":    cfg.AudioFormat,
-				"track_duration":  sched.TrackDuration(),
-				"crossfade":       pipeline.CrossfadeDuration().Seconds(),
-				"llm_model":       ollamaModel,
+				"model":             "acestep-v15-base",
+				"inference_steps":   cfg.InferenceSteps,
+				"guidance_scale":    cfg.GuidanceScale,
+				"shift":             cfg.Shift,
+				"audio_format":      cfg.AudioFormat,
+				"track_duration":    sched.TrackDuration(),
+				"crossfade":         pipeline.CrossfadeDuration().Seconds(),
+				"target_lufs":       targetLUFS,
+				"true_peak_ceiling": peakCeiling,
+				"beat_sync":         pipeline.BeatSync(),
+				"harmonic_mix":      pipeline.HarmonicMix(),
+				"master_gain":       pipeline.MasterGain(),
+				"muted":             pipeline.Muted(),
+				"transition":        pipeline.Transition().String(),
+				"transition_rules":  transitionRules(pipeline),
+				"jingle_rules":      jingleRules(pipeline),
+				"voice_over":        pipeline.VoiceOver(),
+				"standby":           pipeline.Standby().Mode,
+				"llm_model":         ollamaModel,
 			},
 		})
 	})
@@ -178,6 +329,141 @@ func main() {
 		json.NewEncoder(w).Encode(map[string]any{"ok": true})
 	})
 
+	// Queue: GET lists upcoming tracks; DELETE ?id=... drops one before it
+	// airs; PATCH {"id": "...", "play_next": true, "pinned": true} moves or
+	// pins one.
+	mux.HandleFunc("/api/queue", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodDelete:
+			if err := pipeline.RemoveQueued(r.URL.Query().Get("id")); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		case http.MethodPatch:
+			var req struct {
+				ID       string `json:"id"`
+				PlayNext bool   `json:"play_next"`
+				Pinned   *bool  `json:"pinned"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			if req.PlayNext {
+				if err := pipeline.PlayNext(req.ID); err != nil {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+			}
+			if req.Pinned != nil {
+				if err := pipeline.PinQueued(req.ID, *req.Pinned); err != nil {
+					http.Error(w, err.Error(), http.StatusNotFound)
+					return
+				}
+			}
+		default:
+			http.Error(w, "GET, DELETE or PATCH required", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"queue": queueEntries(pipeline)})
+	})
+
+	mux.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
+		entries := []map[string]any{}
+		for _, e := range pipeline.History() {
+			entries = append(entries, map[string]any{
+				"id":         e.Track.ID,
+				"name":       e.Track.Name,
+				"genre":      e.Track.Genre,
+				"caption":    e.Track.Caption,
+				"bpm":        e.Track.BPM,
+				"key":        e.Track.Key.String(),
+				"played_at":  e.PlayedAt,
+				"aired":      e.Aired.Seconds(),
+				"replayable": e.Replayable,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"history": entries})
+	})
+
+	mux.HandleFunc("/api/previous", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := pipeline.Previous(); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	})
+
+	mux.HandleFunc("/api/replay/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := pipeline.Replay(r.PathValue("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "queue": queueEntries(pipeline)})
+	})
+
+	// Waveform: peaks/RMS over the whole source file, plus the regions that
+	// were trimmed and where the track crossfades, in seconds of the file.
+	mux.HandleFunc("/api/tracks/{id}/waveform", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "GET required", http.StatusMethodNotAllowed)
+			return
+		}
+		tl, err := pipeline.Timeline(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		wf := tl.Track.Waveform
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":              tl.Track.ID,
+			"name":            tl.Track.Name,
+			"duration":        wf.Duration.Seconds(),
+			"bucket_duration": wf.BucketDuration().Seconds(),
+			"peaks":           roundLevels(wf.Peaks),
+			"rms":             roundLevels(wf.RMS),
+			"aired":           region(tl.Aired),
+			"trimmed":         []map[string]float64{region(audio.Region{End: tl.Aired.Start}), region(audio.Region{Start: tl.Aired.End, End: wf.Duration})},
+			"crossfade_in":    region(tl.CrossfadeIn),
+			"crossfade_out":   region(tl.CrossfadeOut),
+			"estimated":       tl.Estimated,
+		})
+	})
+
+	mux.HandleFunc("/api/pause", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		pipeline.Pause()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "paused": true})
+	})
+
+	mux.HandleFunc("/api/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		pipeline.Resume()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "paused": false})
+	})
+
 	mux.HandleFunc("/api/autodj", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "POST required", http.StatusMethodNotAllowed)
@@ -207,6 +493,14 @@ func main() {
 		}
 		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, saveName, cfg.AudioFormat))
 		w.Header().Set("Content-Type", "application/octet-stream")
+		// ?trimmed=1 serves the region that actually aired, without the
+		// leading/trailing silence the pipeline cut off.
+		if r.URL.Query().Get("trimmed") == "1" && track.TrimEnd > 0 {
+			if err := audio.ExportTrimmed(r.Context(), w, track.Path, cfg.AudioFormat, track.TrimStart, track.TrimEnd); err != nil {
+				log.Printf("Save trimmed: %v", err)
+			}
+			return
+		}
 		http.ServeFile(w, r, track.Path)
 	})
 
@@ -218,6 +512,16 @@ func main() {
 		var req struct {
 			TrackDuration *int     `json:"track_duration"`
 			Crossfade     *float64 `json:"crossfade"`
+			BeatSync      *bool    `json:"beat_sync"`
+			HarmonicMix   *bool    `json:"harmonic_mix"`
+			MasterGain    *float64 `json:"master_gain"` // dB
+			Mute          *bool    `json:"mute"`
+			VoiceOver     *bool    `json:"voice_over"`
+
+			TransitionStyle *string           `json:"transition_style"`
+			TransitionCurve *string           `json:"transition_curve"`
+			TransitionRules map[string]string `json:"transition_rules"` // "from>to": "style[:curve]"
+			JingleRules     map[string]string `json:"jingle_rules"`     // "genre": "every[:mode]"
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "invalid request", http.StatusBadRequest)
@@ -239,14 +543,219 @@ func main() {
 			}
 			pipeline.SetCrossfade(time.Duration(v * float64(time.Second)))
 		}
+		if req.BeatSync != nil {
+			pipeline.SetBeatSync(*req.BeatSync)
+		}
+		if req.HarmonicMix != nil {
+			pipeline.SetHarmonicMix(*req.HarmonicMix)
+		}
+		if req.VoiceOver != nil {
+			pipeline.SetVoiceOver(*req.VoiceOver)
+		}
+		if req.MasterGain != nil || req.Mute != nil {
+			if req.MasterGain != nil {
+				v := *req.MasterGain
+				if v < audio.MinMasterGain || v > audio.MaxMasterGain {
+					http.Error(w, fmt.Sprintf("master_gain must be %.0f to %+.0f dB", audio.MinMasterGain, audio.MaxMasterGain), http.StatusBadRequest)
+					return
+				}
+				pipeline.SetMasterGain(v)
+			}
+			if req.Mute != nil {
+				pipeline.SetMute(*req.Mute)
+			}
+			saveState()
+		}
+		if req.TransitionStyle != nil || req.TransitionCurve != nil {
+			spec := pipeline.Transition()
+			if req.TransitionStyle != nil {
+				spec.Style = audio.Style(*req.TransitionStyle)
+			}
+			if req.TransitionCurve != nil {
+				spec.Curve = audio.Curve(*req.TransitionCurve)
+			}
+			parsed, err := audio.ParseTransition(spec.String())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			pipeline.SetTransition(parsed)
+		}
+		if req.TransitionRules != nil {
+			rules := make(map[string]audio.TransitionSpec, len(req.TransitionRules))
+			for pair, v := range req.TransitionRules {
+				from, to, ok := strings.Cut(pair, ">")
+				if !ok {
+					http.Error(w, fmt.Sprintf("transition rule %q: want from>to", pair), http.StatusBadRequest)
+					return
+				}
+				spec, err := audio.ParseTransition(v)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				rules[audio.TransitionKey(from, to)] = spec
+			}
+			pipeline.SetTransitionRules(rules)
+		}
+		if req.JingleRules != nil {
+			rules := make(map[string]audio.JingleRule, len(req.JingleRules))
+			for genre, v := range req.JingleRules {
+				rule, err := audio.ParseJingleRule(v)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("jingle rule %q: %v", genre, err), http.StatusBadRequest)
+					return
+				}
+				rules[genre] = rule
+			}
+			pipeline.SetJingleRules(rules)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"ok":               true,
+			"track_duration":   sched.TrackDuration(),
+			"crossfade":        pipeline.CrossfadeDuration().Seconds(),
+			"beat_sync":        pipeline.BeatSync(),
+			"harmonic_mix":     pipeline.HarmonicMix(),
+			"master_gain":      pipeline.MasterGain(),
+			"muted":            pipeline.Muted(),
+			"transition":       pipeline.Transition().String(),
+			"transition_rules": transitionRules(pipeline),
+			"jingle_rules":     jingleRules(pipeline),
+			"voice_over":       pipeline.VoiceOver(),
+		})
+	})
+
+	// Effects: GET lists the output chain; POST adjusts it, keyed by effect
+	// name: {"eq": {"params": {"low": 2}}, "tape": {"bypass": false}}
+	mux.HandleFunc("/api/effects", func(w http.ResponseWriter, r *http.Request) {
+		effects := pipeline.Effects()
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req map[string]struct {
+				Bypass *bool              `json:"bypass"`
+				Params map[string]float64 `json:"params"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			for name, fx := range req {
+				if fx.Bypass != nil {
+					if err := effects.SetBypass(name, *fx.Bypass); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+				}
+				for param, v := range fx.Params {
+					if err := effects.SetParam(name, param, v); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+				}
+			}
+		default:
+			http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"effects": effects.States()})
+	})
+
+	// Ambient overlay: GET lists the settings and available loops; POST
+	// changes them: {"enabled": true, "file": "rain.flac", "gain": -18}
+	mux.HandleFunc("/api/ambient", func(w http.ResponseWriter, r *http.Request) {
+		ambient := pipeline.Ambient()
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				Enabled *bool    `json:"enabled"`
+				File    *string  `json:"file"`
+				Gain    *float64 `json:"gain"` // dB
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			if req.Gain != nil {
+				v := *req.Gain
+				if v < audio.MinAmbientGain || v > audio.MaxAmbientGain {
+					http.Error(w, fmt.Sprintf("gain must be %.0f to %.0f dB", audio.MinAmbientGain, audio.MaxAmbientGain), http.StatusBadRequest)
+					return
+				}
+				ambient.SetGain(v)
+			}
+			if req.File != nil {
+				if err := ambient.SetFile(*req.File); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if req.Enabled != nil {
+				ambient.SetEnabled(*req.Enabled)
+			}
+		default:
+			http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		files, err := ambient.Files()
+		if err != nil {
+			log.Printf("Ambient dir: %v", err)
+		}
+		if files == nil {
+			files = []string{}
+		}
+		state := ambient.State()
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]any{
-			"ok":             true,
-			"track_duration": sched.TrackDuration(),
-			"crossfade":      pipeline.CrossfadeDuration().Seconds(),
+			"enabled": state.Enabled,
+			"file":    state.File,
+			"gain":    state.Gain,
+			"files":   files,
 		})
 	})
 
+	// Announce: GET lists the recorded announcements; POST queues one, or
+	// speaks text through the TTS engine, over the next track change.
+	mux.HandleFunc("/api/announce", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req struct {
+				Text string `json:"text"`
+				File string `json:"file"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Text == "") == (req.File == "") {
+				http.Error(w, "text or file required", http.StatusBadRequest)
+				return
+			}
+			var err error
+			if req.File != "" {
+				err = pipeline.AnnounceFile(req.File)
+			} else {
+				err = pipeline.Announce(req.Text)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		files, err := pipeline.VoiceFiles()
+		if err != nil {
+			log.Printf("Voice dir: %v", err)
+		}
+		if files == nil {
+			files = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "files": files})
+	})
+
 	mux.HandleFunc("/api/rate", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "POST required", http.StatusMethodNotAllowed)
@@ -261,6 +770,10 @@ func main() {
 		}
 		// Phase 1: store rating for future preference learning
 		track, _, _ := pipeline.Status()
+		if track.Interstitial {
+			http.Error(w, "jingles can't be rated", http.StatusConflict)
+			return
+		}
 		log.Printf("Rating: track=%s genre=%s rating=%d", track.ID, track.Genre, req.Rating)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]any{"ok": true})
@@ -280,3 +793,56 @@ func main() {
 		log.Fatalf("HTTP server error: %v", err)
 	}
 }
+
+// transitionRules formats the pipeline's per-genre transition rules for JSON.
+func transitionRules(p *audio.Pipeline) map[string]string {
+	rules := make(map[string]string)
+	for k, v := range p.TransitionRules() {
+		rules[k] = v.String()
+	}
+	return rules
+}
+
+// queueEntries formats the pipeline's queue for JSON. Duration and the
+// analysis fields are zero until a track is decoded.
+func queueEntries(p *audio.Pipeline) []map[string]any {
+	entries := []map[string]any{}
+	for _, e := range p.Queue() {
+		t := e.Track
+		entries = append(entries, map[string]any{
+			"id":       t.ID,
+			"name":     t.Name,
+			"genre":    t.Genre,
+			"caption":  t.Caption,
+			"duration": (t.TrimEnd - t.TrimStart).Seconds(),
+			"bpm":      t.BPM,
+			"key":      t.Key.String(),
+			"decoded":  e.Decoded,
+			"pinned":   e.Pinned,
+		})
+	}
+	return entries
+}
+
+// region formats a span of a track's source file for JSON, in seconds.
+func region(r audio.Region) map[string]float64 {
+	return map[string]float64{"start": r.Start.Seconds(), "end": r.End.Seconds()}
+}
+
+// roundLevels rounds waveform levels to 3 decimals to keep the JSON small.
+func roundLevels(levels []float32) []float64 {
+	out := make([]float64, len(levels))
+	for i, l := range levels {
+		out[i] = math.Round(float64(l)*1000) / 1000
+	}
+	return out
+}
+
+// jingleRules formats the pipeline's per-genre jingle schedule for JSON.
+func jingleRules(p *audio.Pipeline) map[string]string {
+	rules := make(map[string]string)
+	for k, v := range p.JingleRules() {
+		rules[k] = v.String()
+	}
+	return rules
+}
@@ -0,0 +1,203 @@
+package audio
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ambient overlay: a looping background layer (rain, café, vinyl crackle)
+// mixed under the music after the effects chain, so it carries on through
+// track changes, crossfades and genre-change muffling. The loop file is
+// decoded whole into memory, so loops should be short (a minute is ~11MB).
+
+const (
+	ambientFade = 1500 * time.Millisecond // on/off, gain and file change fades
+	ambientSeam = 100 * time.Millisecond  // crossfade baked into the loop point
+
+	MinAmbientGain = -40.0 // dB
+	MaxAmbientGain = 0.0
+)
+
+// audioExts are the file types picked up from loop and jingle directories.
+var audioExts = []string{".flac", ".mp3", ".ogg", ".opus", ".wav", ".m4a"}
+
+// AmbientState is a snapshot of the overlay settings.
+type AmbientState struct {
+	Enabled bool    `json:"enabled"`
+	File    string  `json:"file"`
+	Gain    float64 `json:"gain"` // dB
+}
+
+// Ambient mixes a looping layer into the output. Settings may change from
+// any goroutine; mixing runs on the pipeline goroutine.
+type Ambient struct {
+	mu      sync.Mutex
+	dir     string
+	state   AmbientState
+	loop    []int16 // interleaved stereo, seam already crossfaded
+	pending []int16 // next loop, swapped in once the current one fades out
+	pos     int
+	level   float64 // current linear gain
+	step    float64 // per-sample level change for the fade in progress
+	target  float64 // level the fade is heading to
+}
+
+// NewAmbient creates an overlay with no loop loaded.
+func NewAmbient() *Ambient {
+	return &Ambient{state: AmbientState{Gain: -18}}
+}
+
+// SetDir sets the directory the loop files are chosen from.
+func (a *Ambient) SetDir(dir string) {
+	a.mu.Lock()
+	a.dir = dir
+	a.mu.Unlock()
+}
+
+// Files lists the loop files in the ambient directory.
+func (a *Ambient) Files() ([]string, error) {
+	a.mu.Lock()
+	dir := a.dir
+	a.mu.Unlock()
+	return audioFiles(dir)
+}
+
+// audioFiles lists the audio files in dir by name. An empty dir has none.
+func audioFiles(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && slices.Contains(audioExts, strings.ToLower(filepath.Ext(e.Name()))) {
+			files = append(files, e.Name())
+		}
+	}
+	return files, nil
+}
+
+// SetFile decodes a file from the ambient directory and crossfades to it.
+// Decoding runs FFmpeg to completion, so this blocks for the decode.
+func (a *Ambient) SetFile(name string) error {
+	files, err := a.Files()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(files, name) {
+		return fmt.Errorf("unknown ambient file %q", name)
+	}
+	a.mu.Lock()
+	path := filepath.Join(a.dir, name)
+	a.mu.Unlock()
+
+	samples, err := DecodeFile(path)
+	if err != nil {
+		return err
+	}
+	if err := a.load(name, samples); err != nil {
+		return err
+	}
+	log.Printf("Ambient loop: %s (%v)", name, samplesToDuration(len(samples)/Channels).Round(time.Second))
+	return nil
+}
+
+// load queues decoded samples as the next loop.
+func (a *Ambient) load(name string, samples []int16) error {
+	n := int(ambientSeam.Seconds() * SampleRate)
+	if len(samples) < 4*n*Channels {
+		return fmt.Errorf("ambient file %q too short to loop", name)
+	}
+	a.mu.Lock()
+	a.pending = seamLoop(samples, n)
+	a.state.File = name
+	a.mu.Unlock()
+	return nil
+}
+
+// seamLoop folds the last n sample frames over the first n with an
+// equal-power crossfade and drops them, so the end of the loop runs
+// seamlessly into its start.
+func seamLoop(samples []int16, n int) []int16 {
+	body := len(samples) - n*Channels
+	for i := 0; i < n; i++ {
+		t := (float64(i) + 0.5) / float64(n) * math.Pi / 2
+		for ch := 0; ch < Channels; ch++ {
+			head, tail := float64(samples[i*Channels+ch]), float64(samples[body+i*Channels+ch])
+			v := math.Round(tail*math.Cos(t) + head*math.Sin(t))
+			samples[i*Channels+ch] = int16(max(-32768, min(32767, v)))
+		}
+	}
+	return samples[:body]
+}
+
+// SetEnabled fades the overlay in or out.
+func (a *Ambient) SetEnabled(enabled bool) {
+	a.mu.Lock()
+	a.state.Enabled = enabled
+	a.mu.Unlock()
+	log.Printf("Ambient overlay: %v", enabled)
+}
+
+// SetGain sets the overlay level in dB, clamped to
+// [MinAmbientGain, MaxAmbientGain].
+func (a *Ambient) SetGain(db float64) {
+	db = math.Max(MinAmbientGain, math.Min(MaxAmbientGain, db))
+	a.mu.Lock()
+	a.state.Gain = db
+	a.mu.Unlock()
+	log.Printf("Ambient gain set to %.1f dB", db)
+}
+
+// State returns the overlay settings.
+func (a *Ambient) State() AmbientState {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state
+}
+
+// mix adds the overlay to frame. While hold is set (station paused) the
+// overlay fades out with everything else.
+func (a *Ambient) mix(frame []float32, hold bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	target := 0.0
+	if a.state.Enabled && !hold && a.pending == nil {
+		target = math.Pow(10, a.state.Gain/20)
+	}
+	if a.pending != nil && a.level == 0 {
+		a.loop, a.pending, a.pos = a.pending, nil, 0
+		return // fades in from the next frame
+	}
+	if target != a.target {
+		a.target = target
+		a.step = math.Abs(target-a.level) / (ambientFade.Seconds() * SampleRate)
+	}
+	if len(a.loop) == 0 || (a.level == 0 && a.target == 0) {
+		a.level = a.target
+		return
+	}
+
+	for i := 0; i+Channels <= len(frame); i += Channels {
+		if a.level < a.target {
+			a.level = math.Min(a.target, a.level+a.step)
+		} else if a.level > a.target {
+			a.level = math.Max(a.target, a.level-a.step)
+		}
+		for ch := 0; ch < Channels; ch++ {
+			frame[i+ch] += float32(float64(a.loop[a.pos+ch]) / 32768 * a.level)
+		}
+		a.pos = (a.pos + Channels) % len(a.loop)
+	}
+}
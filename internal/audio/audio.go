@@ -14,8 +14,34 @@ const (
 
 // TrackInfo identifies a generated track for the pipeline.
 type TrackInfo struct {
-	ID    string
-	Genre string
-	Path  string
-	Name  string // display name (LLM-generated or deterministic)
+	ID      string
+	Genre   string
+	Path    string
+	Name    string // display name (LLM-generated or deterministic)
+	Caption string // ACE-Step generation caption
+
+	// Interstitial marks station IDs and jingles: not generated, and kept
+	// out of history, ratings and the buffered track count.
+	Interstitial bool
+
+	// Set by the pipeline after decode
+	Loudness float64   // integrated loudness before normalization (LUFS)
+	TruePeak float64   // true peak before normalization (dBTP)
+	Gain     float64   // normalization gain applied (dB)
+	BPM      float64   // detected tempo, 0 if not confident
+	Key      Key       // detected key, zero if not confident
+	Centroid float64   // average spectral centroid (Hz), i.e. brightness
+	Energy   []float32 // RMS per second of the aired region, after normalization
+	Waveform Waveform  // whole source file, after normalization
+
+	// Region of the source file that is played after silence trimming
+	// (and, for a beat-aligned crossfade, the skipped head)
+	TrimStart time.Duration
+	TrimEnd   time.Duration
+
+	// Set by the pipeline as the track goes on air: how much of the
+	// played region overlaps the previous track, and the planned overlap
+	// with the next one
+	FadeIn  time.Duration
+	FadeOut time.Duration
 }
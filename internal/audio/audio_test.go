@@ -1,6 +1,14 @@
 package audio
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -119,6 +127,254 @@ func TestCrossfadeClipping(t *testing.T) {
 	}
 }
 
+// --- Transitions ---
+
+func TestCurveEndpoints(t *testing.T) {
+	for _, c := range Curves {
+		if out, in := c.Gains(0); out != 1 || in != 0 {
+			t.Errorf("%s at 0: out=%v in=%v, want 1/0", c, out, in)
+		}
+		out, in := c.Gains(1)
+		if math.Abs(out) > 1e-12 || math.Abs(in-1) > 1e-12 {
+			t.Errorf("%s at 1: out=%v in=%v, want 0/1", c, out, in)
+		}
+	}
+}
+
+func TestEqualPowerConstantPower(t *testing.T) {
+	for i := 0; i <= 10; i++ {
+		out, in := CurveEqualPower.Gains(float64(i) / 10)
+		if p := out*out + in*in; math.Abs(p-1) > 1e-9 {
+			t.Errorf("Equal-power at %v: power = %v, want 1", float64(i)/10, p)
+		}
+	}
+}
+
+func TestParseTransition(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    TransitionSpec
+		wantErr bool
+	}{
+		{"crossfade", TransitionSpec{StyleCrossfade, CurveEqualPower}, false},
+		{"echo-out:linear", TransitionSpec{StyleEchoOut, CurveLinear}, false},
+		{" lowpass-sweep:log ", TransitionSpec{StyleLowPassSweep, CurveLogarithmic}, false},
+		{"fade-to-grey", TransitionSpec{}, true},
+		{"crossfade:cubic", TransitionSpec{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTransition(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTransition(%q) = %v, %v; want %v, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseTransitionRules(t *testing.T) {
+	rules, err := ParseTransitionRules("ambient>rock=hard-cut, jazz>*=echo-out:linear,")
+	if err != nil {
+		t.Fatalf("ParseTransitionRules: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Got %d rules, want 2", len(rules))
+	}
+	if rules["ambient>rock"].Style != StyleHardCut {
+		t.Errorf("ambient>rock = %v, want hard-cut", rules["ambient>rock"])
+	}
+	if rules["jazz>*"] != (TransitionSpec{StyleEchoOut, CurveLinear}) {
+		t.Errorf("jazz>* = %v, want echo-out:linear", rules["jazz>*"])
+	}
+	for _, bad := range []string{"ambient=hard-cut", "ambient>rock", "a>b=nope"} {
+		if _, err := ParseTransitionRules(bad); err == nil {
+			t.Errorf("ParseTransitionRules(%q) should fail", bad)
+		}
+	}
+}
+
+func TestTransitionForPrecedence(t *testing.T) {
+	p := NewPipeline(4 * time.Second)
+	p.SetTransitionRules(map[string]TransitionSpec{
+		"ambient>rock": {StyleHardCut, CurveLinear},
+		"ambient>*":    {StyleEchoOut, CurveLinear},
+		"*>rock":       {StyleLowPassSweep, CurveLinear},
+	})
+	tests := []struct {
+		from, to string
+		want     Style
+	}{
+		{"ambient", "rock", StyleHardCut},
+		{"ambient", "jazz", StyleEchoOut},
+		{"jazz", "rock", StyleLowPassSweep},
+		{"jazz", "lofi hip hop", StyleCrossfade},
+	}
+	for _, tt := range tests {
+		if got := p.transitionFor(tt.from, tt.to).Style; got != tt.want {
+			t.Errorf("transitionFor(%q, %q) = %s, want %s", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestHardCutSwitchesAtMidpoint(t *testing.T) {
+	tr := TransitionSpec{Style: StyleHardCut}.New()
+	out, in := []float32{0.5, 0.5}, []float32{-0.25, -0.25}
+	if got := tr.Mix(out, in, 0.49); got[0] != 0.5 {
+		t.Errorf("Before midpoint got %v, want outgoing", got[0])
+	}
+	if got := tr.Mix(out, in, 0.5); got[0] != -0.25 {
+		t.Errorf("At midpoint got %v, want incoming", got[0])
+	}
+}
+
+func TestLowPassSweepMutesHighs(t *testing.T) {
+	tr := TransitionSpec{Style: StyleLowPassSweep, Curve: CurveLinear}.New()
+	hi := Int16ToFloat(sine(8000, -6, 0.2))
+	silent := make([]float32, FrameSamples)
+	var last []float32
+	for i := 0; i+FrameSamples <= len(hi); i += FrameSamples {
+		last = tr.Mix(hi[i:i+FrameSamples], silent, 0.4)
+	}
+	var peak float32
+	for _, v := range last {
+		if v > peak {
+			peak = v
+		}
+	}
+	// At 40% the cutoff is ~3kHz: an 8kHz tone at half scale should be
+	// well attenuated beyond the 0.6 linear gain.
+	if peak > 0.12 {
+		t.Errorf("8kHz peak through sweep = %v, want heavy attenuation", peak)
+	}
+}
+
+func TestEchoOutLeavesTail(t *testing.T) {
+	tr := TransitionSpec{Style: StyleEchoOut, Curve: CurveLinear}.New()
+	loud := make([]float32, FrameSamples)
+	for i := range loud {
+		loud[i] = 0.3
+	}
+	silent := make([]float32, FrameSamples)
+	for i := 0; i < 25; i++ { // feed 500ms of signal
+		tr.Mix(loud, silent, 0.1)
+	}
+	// Outgoing dry signal is gone past the midpoint but echoes remain.
+	got := tr.Mix(silent, silent, 0.6)
+	nonZero := false
+	for _, v := range got {
+		if v != 0 {
+			nonZero = true
+			break
+		}
+	}
+	if !nonZero {
+		t.Error("echo-out produced no echo tail")
+	}
+}
+
+func TestCrossfadeMixDoesNotClip(t *testing.T) {
+	tr := TransitionSpec{Style: StyleCrossfade, Curve: CurveLinear}.New()
+	got := tr.Mix([]float32{0.9, -0.9}, []float32{0.9, -0.9}, 0.5)
+	if got[0] != 0.9 || got[1] != -0.9 {
+		t.Errorf("Mid-fade mix = %v, want [0.9 -0.9]", got)
+	}
+	got = TransitionSpec{Style: StyleEchoOut, Curve: CurveLinear}.New().Mix([]float32{1.5}, []float32{1.5}, 0)
+	if got[0] <= 1 {
+		t.Errorf("Overs should pass through to the limiter, got %v", got[0])
+	}
+}
+
+// --- Limiter / float bus ---
+
+func TestLimiterHoldsCeiling(t *testing.T) {
+	l := NewLimiter(-1, 5*time.Millisecond)
+	ceiling := float32(math.Pow(10, -1.0/20))
+	// A 3x over-driven sine (~+9.5 dBFS) with a sudden onset.
+	src := Int16ToFloat(sine(1000, -6, 1))
+	var peak float32
+	for i := 0; i+FrameSamples <= len(src); i += FrameSamples {
+		frame := make([]float32, FrameSamples)
+		for j := range frame {
+			frame[j] = src[i+j] * 6
+		}
+		for _, v := range l.Process(frame) {
+			peak = max(peak, v, -v)
+		}
+	}
+	if peak > ceiling+1e-6 {
+		t.Errorf("Limited peak = %v, want <= %v", peak, ceiling)
+	}
+	if peak < ceiling*0.9 {
+		t.Errorf("Limited peak = %v, limiter is over-attenuating", peak)
+	}
+}
+
+func TestLimiterTransparentBelowCeiling(t *testing.T) {
+	l := NewLimiter(-1, 5*time.Millisecond)
+	delay := int(0.005 * SampleRate * Channels)
+	src := Int16ToFloat(sine(440, -12, 0.1))
+	var out []float32
+	for i := 0; i+FrameSamples <= len(src); i += FrameSamples {
+		frame := make([]float32, FrameSamples)
+		copy(frame, src[i:])
+		out = append(out, l.Process(frame)...)
+	}
+	for i := delay; i < len(out); i++ {
+		if out[i] != src[i-delay] {
+			t.Fatalf("Sample %d = %v, want %v delayed unchanged", i, out[i], src[i-delay])
+		}
+	}
+}
+
+func TestLimiterFollowsLoudnessTarget(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	p.limiter = NewLimiter(-1, limiterLookahead)
+	loud := func() []float32 {
+		frame := make([]float32, FrameSamples)
+		for i := range frame {
+			frame[i] = 0.95
+		}
+		return frame
+	}
+	peakOf := func(frames [][]float32) float32 {
+		var peak float32
+		for _, f := range frames {
+			for _, v := range f {
+				peak = max(peak, v, -v)
+			}
+		}
+		return peak
+	}
+
+	for range 5 {
+		p.sendFrame(context.Background(), loud())
+	}
+	if peak := peakOf(drain(p)[1:]); peak > float32(math.Pow(10, -1.0/20))+1e-6 {
+		t.Errorf("Peak at -1 dBTP = %v", peak)
+	}
+	p.SetLoudnessTarget(-14, -6)
+	for range 5 {
+		p.sendFrame(context.Background(), loud())
+	}
+	// The first frame after the change still holds the look-ahead window
+	// limited against the old ceiling.
+	if peak, want := peakOf(drain(p)[1:]), float32(math.Pow(10, -6.0/20)); peak > want+1e-6 {
+		t.Errorf("Peak after lowering the ceiling to -6 dBTP = %v, want <= %v", peak, want)
+	}
+}
+
+func TestFloatToInt16(t *testing.T) {
+	original := []int16{0, 1, -1, 32767, -32768, 12345}
+	got := FloatToInt16(Int16ToFloat(original))
+	for i := range original {
+		if got[i] != original[i] {
+			t.Errorf("Round trip [%d] = %d, want %d", i, got[i], original[i])
+		}
+	}
+	clipped := FloatToInt16([]float32{1.5, -1.5})
+	if clipped[0] != 32767 || clipped[1] != -32768 {
+		t.Errorf("FloatToInt16 overs = %v, want [32767 -32768]", clipped)
+	}
+}
+
 // --- SamplesToBytes / round-trip ---
 
 func TestSamplesToBytes(t *testing.T) {
@@ -153,6 +409,345 @@ func TestSamplesBytesRoundTrip(t *testing.T) {
 	}
 }
 
+// --- Loudness ---
+
+// sine returns interleaved stereo samples of a sine at the given peak dBFS.
+func sine(freq, dbfs float64, seconds float64) []int16 {
+	amp := math.Pow(10, dbfs/20) * 32767
+	n := int(seconds * SampleRate)
+	samples := make([]int16, n*Channels)
+	for i := 0; i < n; i++ {
+		v := int16(amp * math.Sin(2*math.Pi*freq*float64(i)/SampleRate))
+		samples[i*2] = v
+		samples[i*2+1] = v
+	}
+	return samples
+}
+
+func TestMeasureLoudnessSine(t *testing.T) {
+	// EBU Tech 3341 case 1: stereo 1kHz sine at -23 dBFS reads -23 LUFS
+	lufs, peak := MeasureLoudness(sine(1000, -23, 5))
+	if math.Abs(lufs-(-23)) > 0.3 {
+		t.Errorf("Integrated loudness = %.2f LUFS, want -23 +/- 0.3", lufs)
+	}
+	if math.Abs(peak-(-23)) > 0.3 {
+		t.Errorf("True peak = %.2f dBTP, want about -23", peak)
+	}
+}
+
+func TestMeasureLoudnessSilence(t *testing.T) {
+	lufs, peak := MeasureLoudness(make([]int16, SampleRate*Channels))
+	if lufs != loudnessFloor {
+		t.Errorf("Silence loudness = %v, want %v", lufs, loudnessFloor)
+	}
+	if peak != peakFloor {
+		t.Errorf("Silence peak = %v, want %v", peak, peakFloor)
+	}
+}
+
+func TestNormalizationGain(t *testing.T) {
+	tests := []struct {
+		name                        string
+		lufs, peak, target, ceiling float64
+		want                        float64
+	}{
+		{"quiet track boosted", -24, -12, -14, -1, 10},
+		{"loud track cut", -8, -0.5, -14, -1, -6},
+		{"boost limited by ceiling", -24, -6, -14, -1, 5},
+		{"silence untouched", loudnessFloor, peakFloor, -14, -1, 0},
+	}
+	for _, tt := range tests {
+		got := NormalizationGain(tt.lufs, tt.peak, tt.target, tt.ceiling)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: gain = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyGainNormalizes(t *testing.T) {
+	samples := sine(1000, -30, 3)
+	lufs, peak := MeasureLoudness(samples)
+	ApplyGain(samples, NormalizationGain(lufs, peak, -18, -1))
+	got, _ := MeasureLoudness(samples)
+	if math.Abs(got-(-18)) > 0.3 {
+		t.Errorf("After normalization loudness = %.2f LUFS, want -18", got)
+	}
+}
+
+func TestApplyGainClips(t *testing.T) {
+	samples := []int16{20000, -20000}
+	ApplyGain(samples, 12)
+	if samples[0] != 32767 || samples[1] != -32768 {
+		t.Errorf("ApplyGain should clip, got %v", samples)
+	}
+}
+
+// --- Beat detection ---
+
+// kickTrack renders a 4/4 kick pattern at bpm with accented downbeats,
+// starting at offset samples. Returns interleaved stereo samples.
+func kickTrack(bpm float64, offset int, seconds float64) []int16 {
+	n := int(seconds * SampleRate)
+	samples := make([]int16, n*Channels)
+	period := 60 / bpm * SampleRate
+	for beat := 0; ; beat++ {
+		start := offset + int(float64(beat)*period)
+		if start >= n {
+			break
+		}
+		amp := 8000.0
+		if beat%4 == 0 {
+			amp = 20000
+		}
+		for i := 0; i < SampleRate/10 && start+i < n; i++ {
+			env := math.Exp(-float64(i) / (SampleRate / 40))
+			v := int16(amp * env * math.Sin(2*math.Pi*60*float64(i)/SampleRate))
+			samples[(start+i)*2] = v
+			samples[(start+i)*2+1] = v
+		}
+	}
+	return samples
+}
+
+func TestDetectBeatsTempo(t *testing.T) {
+	for _, bpm := range []float64{90, 120, 128, 150} {
+		g := DetectBeats(kickTrack(bpm, 0, 30))
+		if !g.Confident() {
+			t.Errorf("%v BPM: grid not confident (confidence %.2f)", bpm, g.Confidence)
+			continue
+		}
+		if math.Abs(g.BPM-bpm) > 0.5 {
+			t.Errorf("Detected %.2f BPM, want %v", g.BPM, bpm)
+		}
+	}
+}
+
+func TestDetectBeatsDownbeat(t *testing.T) {
+	// First accented beat at 0.3s; downbeats every 2s at 120 BPM
+	offset := SampleRate * 3 / 10
+	g := DetectBeats(kickTrack(120, offset, 30))
+	if !g.Confident() {
+		t.Fatalf("Grid not confident (confidence %.2f)", g.Confidence)
+	}
+	tolerance := SampleRate / 50 // 20ms
+	for _, want := range []int{offset, offset + 2*SampleRate, offset + 20*SampleRate} {
+		got := g.NextDownbeat(want - SampleRate/2)
+		if d := got - want; d > tolerance || d < -tolerance {
+			t.Errorf("NextDownbeat near %d = %d, off by %d samples", want, got, d)
+		}
+	}
+	if prev := g.PrevDownbeat(offset + 3*SampleRate); math.Abs(float64(prev-(offset+2*SampleRate))) > float64(tolerance) {
+		t.Errorf("PrevDownbeat = %d, want about %d", prev, offset+2*SampleRate)
+	}
+}
+
+func TestDetectBeatsSilence(t *testing.T) {
+	g := DetectBeats(make([]int16, 10*SampleRate*Channels))
+	if g.Confident() {
+		t.Errorf("Silence should not produce a confident grid: %+v", g)
+	}
+	if g.PrevDownbeat(SampleRate) != -1 || g.NextDownbeat(0) != -1 {
+		t.Error("Unconfident grid should report no downbeats")
+	}
+}
+
+func TestAlignIncoming(t *testing.T) {
+	samples := kickTrack(120, SampleRate/2, 20)
+	next := newTestTrack(samples)
+	next.beats = DetectBeats(samples)
+	skip, ok := alignIncoming(next, 100)
+	if !ok {
+		t.Fatal("alignIncoming failed on a confident grid")
+	}
+	if next.length != len(samples)/Channels-skip {
+		t.Errorf("Incoming length not reduced by skip: length=%d skip=%d", next.length, skip)
+	}
+	if src := next.src.(*sliceSource); src.pos != skip*Channels {
+		t.Errorf("Stream position = %d, want %d", src.pos, skip*Channels)
+	}
+	if db := next.beats.NextDownbeat(0); db != 100 {
+		t.Errorf("First downbeat after alignment = %d, want 100", db)
+	}
+
+	flat := newTestTrack(make([]int16, SampleRate*Channels))
+	if _, ok := alignIncoming(flat, 100); ok {
+		t.Error("alignIncoming should leave tracks without tempo untouched")
+	}
+}
+
+// --- Streaming decode ---
+
+// sliceSource is an in-memory frameSource for tests.
+type sliceSource struct {
+	samples []float32
+	pos     int
+	closed  bool
+}
+
+func (s *sliceSource) ReadFrame() ([]float32, bool) {
+	if s.pos+FrameSamples > len(s.samples) {
+		return nil, false
+	}
+	frame := s.samples[s.pos : s.pos+FrameSamples]
+	s.pos += FrameSamples
+	return frame, true
+}
+
+func (s *sliceSource) Skip(n int) {
+	s.pos = min(s.pos+n*Channels, len(s.samples))
+}
+
+func (s *sliceSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+func newTestTrack(samples []int16) *decodedTrack {
+	return &decodedTrack{
+		src:    &sliceSource{samples: Int16ToFloat(samples)},
+		length: len(samples) / Channels,
+	}
+}
+
+// newTestStream wraps raw PCM in a Stream without an FFmpeg process.
+func newTestStream(samples []int16, gain float64) *Stream {
+	return &Stream{r: bufio.NewReader(bytes.NewReader(SamplesToBytes(samples))), gain: gain}
+}
+
+func TestStreamReadFrames(t *testing.T) {
+	samples := make([]int16, FrameSamples*3+10)
+	for i := range samples {
+		samples[i] = int16(i % 1000)
+	}
+	s := newTestStream(samples, 2)
+	for f := 0; f < 3; f++ {
+		frame, ok := s.ReadFrame()
+		if !ok {
+			t.Fatalf("ReadFrame %d failed", f)
+		}
+		if len(frame) != FrameSamples {
+			t.Fatalf("Frame length = %d, want %d", len(frame), FrameSamples)
+		}
+		if want := float32(samples[f*FrameSamples+7]) * 2 / 32768; frame[7] != want {
+			t.Errorf("Frame %d sample 7 = %v, want %v (gain applied)", f, frame[7], want)
+		}
+	}
+	if _, ok := s.ReadFrame(); ok {
+		t.Error("Partial trailing frame should not be returned")
+	}
+}
+
+func TestStreamPrefetchBounded(t *testing.T) {
+	s := newTestStream(make([]int16, FrameSamples*50), 1)
+	s.Prefetch(10)
+	if got := s.Buffered(); got != 10 {
+		t.Errorf("Buffered after Prefetch(10) = %d, want 10", got)
+	}
+	s.ReadFrame()
+	if got := s.Buffered(); got != 9 {
+		t.Errorf("Buffered after one read = %d, want 9", got)
+	}
+}
+
+func TestStreamSkip(t *testing.T) {
+	samples := make([]int16, FrameSamples*4)
+	for i := range samples {
+		samples[i] = int16(i / Channels)
+	}
+	s := newTestStream(samples, 1)
+	s.Skip(1000) // more than one frame, not frame-aligned
+	frame, ok := s.ReadFrame()
+	if !ok || frame[0] != 1000.0/32768 {
+		t.Errorf("After Skip(1000) first sample = %v, want 1000/32768", frame[0]*32768)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	// A trailing partial frame is dropped, as in playback
+	samples := kickTrack(120, 0, 12)
+	samples = append(samples, make([]int16, FrameSamples/2)...)
+	a := analyze(&sliceSource{samples: Int16ToFloat(samples)})
+	if a.Samples != 12*SampleRate {
+		t.Errorf("Analysis length = %d samples, want %d", a.Samples, 12*SampleRate)
+	}
+	if !a.Beats.Confident() || math.Abs(a.Beats.BPM-120) > 0.5 {
+		t.Errorf("Analysis beats = %+v, want 120 BPM", a.Beats)
+	}
+	if a.Loudness <= loudnessFloor {
+		t.Errorf("Analysis loudness = %v, want above floor", a.Loudness)
+	}
+}
+
+// --- Silence trimming ---
+
+// padded surrounds a 2s tone with lead and tail seconds of near-silence.
+func padded(lead, tail float64) []int16 {
+	var samples []int16
+	hiss := func(seconds float64) {
+		n := int(seconds*SampleRate) * Channels
+		for i := 0; i < n; i++ {
+			samples = append(samples, int16(i%3-1)) // ~-90 dBFS
+		}
+	}
+	hiss(lead)
+	samples = append(samples, sine(440, -12, 2)...)
+	hiss(tail)
+	return samples
+}
+
+func trimOf(samples []int16, gainDB float64, cfg SilenceConfig) (int, int, int) {
+	a := analyze(&sliceSource{samples: Int16ToFloat(samples)})
+	start, end := trimBounds(a.levels, a.Samples, gainDB, cfg)
+	return start, end, a.Samples
+}
+
+func TestTrimBounds(t *testing.T) {
+	cfg := SilenceConfig{ThresholdDB: -50, MinDuration: 300 * time.Millisecond}
+	start, end, length := trimOf(padded(1.5, 2), 0, cfg)
+
+	wantStart := int(1.5*SampleRate) - trimMargin
+	if d := start - wantStart; d < -levelHop || d > levelHop {
+		t.Errorf("Trim start = %d, want about %d", start, wantStart)
+	}
+	wantEnd := int(3.5*SampleRate) + trimMargin
+	if d := end - wantEnd; d < -levelHop || d > levelHop {
+		t.Errorf("Trim end = %d, want about %d", end, wantEnd)
+	}
+	if end >= length {
+		t.Errorf("Trailing silence not trimmed: end=%d length=%d", end, length)
+	}
+}
+
+func TestTrimBoundsKeepsShortSilence(t *testing.T) {
+	cfg := SilenceConfig{ThresholdDB: -50, MinDuration: 500 * time.Millisecond}
+	start, end, length := trimOf(padded(0.2, 0.2), 0, cfg)
+	if start != 0 || end != length {
+		t.Errorf("Silences under MinDuration should be kept: got [%d, %d) of %d", start, end, length)
+	}
+}
+
+func TestTrimBoundsDisabledAndSilent(t *testing.T) {
+	start, end, length := trimOf(padded(1, 1), 0, SilenceConfig{ThresholdDB: -50})
+	if start != 0 || end != length {
+		t.Errorf("MinDuration 0 should disable trimming: got [%d, %d)", start, end)
+	}
+	cfg := SilenceConfig{ThresholdDB: -50, MinDuration: 300 * time.Millisecond}
+	start, end, length = trimOf(make([]int16, SampleRate*Channels), 0, cfg)
+	if start != 0 || end != length {
+		t.Errorf("All-silent track should not be trimmed away: got [%d, %d)", start, end)
+	}
+}
+
+func TestTrimBoundsUsesPlaybackGain(t *testing.T) {
+	// The tone is at -12 dBFS; with -45 dB of gain it falls under -50 dBFS
+	// and the whole track counts as silence.
+	cfg := SilenceConfig{ThresholdDB: -50, MinDuration: 300 * time.Millisecond}
+	start, end, length := trimOf(padded(1, 1), -45, cfg)
+	if start != 0 || end != length {
+		t.Errorf("Threshold should apply after gain: got [%d, %d) of %d", start, end, length)
+	}
+}
+
 // --- Pipeline unit tests (non-I/O) ---
 
 func TestNewPipeline(t *testing.T) {
@@ -163,6 +758,9 @@ func TestNewPipeline(t *testing.T) {
 	if p.crossfadeDur != 8*time.Second {
 		t.Errorf("crossfadeDur = %v, want 8s", p.crossfadeDur)
 	}
+	if lufs, ceiling := p.LoudnessTarget(); lufs != -14 || ceiling != -1 {
+		t.Errorf("LoudnessTarget = %v/%v, want -14/-1", lufs, ceiling)
+	}
 }
 
 func TestPipelineQueueSize(t *testing.T) {
@@ -186,3 +784,1432 @@ func TestPipelineSkipNonBlocking(t *testing.T) {
 	p.Skip()
 	p.Skip() // second skip also shouldn't block (buffered channel of 1, first fills it)
 }
+
+// --- Clock / playback ---
+
+func newTestPipeline(crossfade time.Duration) (*Pipeline, *FakeClock) {
+	p := NewPipeline(crossfade)
+	p.beatSync = false
+	clk := NewFakeClock(time.Unix(0, 0))
+	p.SetClock(clk)
+	return p, clk
+}
+
+// drain returns the frames the pipeline has sent so far.
+func drain(p *Pipeline) [][]float32 {
+	var frames [][]float32
+	for {
+		select {
+		case f := <-p.frameCh:
+			frames = append(frames, f)
+		default:
+			return frames
+		}
+	}
+}
+
+func TestPlayTrackPacesFrames(t *testing.T) {
+	p, clk := newTestPipeline(0)
+	start := clk.Now()
+	dt := newTestTrack(make([]int16, 10*FrameSamples))
+	if next, _ := p.playTrack(context.Background(), dt, 0); next != nil {
+		t.Fatal("playTrack returned a next track with an empty queue")
+	}
+	if got := len(drain(p)); got != 10 {
+		t.Errorf("Sent %d frames, want 10", got)
+	}
+	// The first frame is due immediately; each later one 20ms after.
+	if got := clk.Now().Sub(start); got != 9*FrameDuration {
+		t.Errorf("Clock advanced %v, want %v", got, 9*FrameDuration)
+	}
+	if !dt.src.(*sliceSource).closed {
+		t.Error("Track stream not closed after playback")
+	}
+	if s := p.ClockStats(); s.Frames != 10 || s.Late != 0 || s.MaxLate != 0 {
+		t.Errorf("ClockStats = %+v, want 10 on-time frames", s)
+	}
+}
+
+func TestPlayTrackCrossfade(t *testing.T) {
+	p, _ := newTestPipeline(time.Second) // 50 frames
+	dt := newTestTrack(make([]int16, 100*FrameSamples))
+	next := newTestTrack(make([]int16, 100*FrameSamples))
+	queueDecoded(p, next)
+
+	got, mixed := p.playTrack(context.Background(), dt, 0)
+	if got != next || mixed != 50 {
+		t.Fatalf("playTrack = %p, %d; want next track after 50 mixed frames", got, mixed)
+	}
+	if next.frames() != 50 {
+		t.Errorf("Incoming track has %d frames left, want 50", next.frames())
+	}
+	if sent := len(drain(p)); sent != 100 {
+		t.Errorf("Sent %d frames, want 100 (50 solo + 50 mixed)", sent)
+	}
+	if next.src.(*sliceSource).closed {
+		t.Error("Incoming stream closed after crossfade")
+	}
+}
+
+func TestOnAirAcrossCrossfade(t *testing.T) {
+	p, _ := newTestPipeline(time.Second) // 50 frames
+	p.frameCh = make(chan []float32)     // step through the frames
+	dt := newTestTrack(make([]int16, 100*FrameSamples))
+	dt.info = TrackInfo{ID: "out", Name: "Outgoing"}
+	next := newTestTrack(make([]int16, 100*FrameSamples))
+	next.info = TrackInfo{ID: "in", Name: "Incoming"}
+	queueDecoded(p, next)
+
+	done := make(chan struct{})
+	go func() {
+		p.playTrack(context.Background(), dt, 0)
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		<-p.frameCh
+		// The crossfade runs from frame 50; its midpoint is frame 75.
+		if want := map[int]string{0: "out", 73: "out", 75: "in", 99: "in"}[i]; want != "" {
+			if got := p.OnAir().ID; got != want {
+				t.Errorf("OnAir after frame %d = %q, want %q", i, got, want)
+			}
+		}
+	}
+	<-done
+
+	p.setTrack(TrackInfo{ID: "jingle-1", Interstitial: true}, 10)
+	if got := p.OnAir().ID; got != "" {
+		t.Errorf("OnAir during a jingle with no history = %q, want none", got)
+	}
+	p.setTrack(next.info, 50)
+	p.recordHistory()
+	p.setTrack(TrackInfo{ID: "jingle-2", Interstitial: true}, 10)
+	if got := p.OnAir().ID; got != "in" {
+		t.Errorf("OnAir during a jingle = %q, want the last track", got)
+	}
+}
+
+func TestCrossfadeIntoShortJingle(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		frames, read int // jingle length and frames its stream actually has
+		wantMixed    int
+	}{
+		{"short", 20, 20, 10}, // crossfade capped at half the jingle
+		{"ends early", 40, 10, 10},
+	} {
+		p, _ := newTestPipeline(time.Second) // 50 frames
+		dt := newTestTrack(make([]int16, 100*FrameSamples))
+		jingle := newTestTrack(make([]int16, tc.read*FrameSamples))
+		jingle.length = tc.frames * FrameSize
+		jingle.info = TrackInfo{ID: "jingle-1", Interstitial: true}
+		queueDecoded(p, jingle)
+
+		next, mixed := p.playTrack(context.Background(), dt, 0)
+		if next != jingle || mixed != tc.wantMixed {
+			t.Errorf("%s: playTrack = %v, %d; want the jingle, %d", tc.name, next, mixed, tc.wantMixed)
+		}
+		// The outgoing track plays to its end rather than being cut off.
+		if n := len(drain(p)); n != 100 {
+			t.Errorf("%s: sent %d frames, want all 100 of the outgoing track", tc.name, n)
+		}
+	}
+
+	// Shortened, the crossfade no longer starts on the downbeat, so the
+	// jingle isn't trimmed to line up with it.
+	p, _ := newTestPipeline(time.Second)
+	p.beatSync = true
+	grid := BeatGrid{BPM: 120, Confidence: 1, Period: SampleRate / 2, Downbeat: 5000}
+	dt := newTestTrack(make([]int16, 100*FrameSamples))
+	dt.beats = grid
+	jingle := newTestTrack(make([]int16, 20*FrameSamples))
+	jingle.beats = grid
+	jingle.info = TrackInfo{ID: "jingle-1", Interstitial: true}
+	queueDecoded(p, jingle)
+	if next, mixed := p.playTrack(context.Background(), dt, 0); next != jingle || mixed != 10 || next.frames() != 10 {
+		t.Errorf("Beat sync: playTrack = %v, %d; want the untrimmed jingle, 10", next, mixed)
+	}
+}
+
+func TestPlayTrackSkip(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	dt := newTestTrack(make([]int16, 10*FrameSamples))
+	p.Skip()
+	if next, _ := p.playTrack(context.Background(), dt, 0); next != nil {
+		t.Error("Skipped track returned a next track")
+	}
+	if n := len(drain(p)); n != 0 {
+		t.Errorf("Skipped track sent %d frames, want 0", n)
+	}
+	if !dt.src.(*sliceSource).closed {
+		t.Error("Skipped track stream not closed")
+	}
+}
+
+func TestClockCatchesUpAfterStall(t *testing.T) {
+	p, clk := newTestPipeline(0)
+	ctx := context.Background()
+	frame := make([]float32, FrameSamples)
+	for i := 0; i < 5; i++ {
+		p.sendFrame(ctx, frame)
+	}
+
+	// Stall for 100ms: the frames due at 100, 120, ..., 180ms are now
+	// due or overdue and go out back to back without waiting.
+	stalled := clk.Advance(100 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		p.sendFrame(ctx, frame)
+	}
+	if !clk.Now().Equal(stalled) {
+		t.Errorf("Clock advanced by %v while catching up", clk.Now().Sub(stalled))
+	}
+	p.sendFrame(ctx, frame)
+	if got := clk.Now().Sub(stalled); got != FrameDuration {
+		t.Errorf("First frame after catching up waited %v, want %v", got, FrameDuration)
+	}
+
+	s := p.ClockStats()
+	if s.MaxLate != 80*time.Millisecond || s.Late != 3 || s.Resyncs != 0 {
+		t.Errorf("ClockStats = %+v, want max 80ms, 3 late, no resync", s)
+	}
+
+	// A stall longer than maxCatchUp resets the schedule instead.
+	clk.Advance(5 * time.Second)
+	p.sendFrame(ctx, frame)
+	before := clk.Now()
+	p.sendFrame(ctx, frame)
+	if got := clk.Now().Sub(before); got != FrameDuration {
+		t.Errorf("Frame after resync waited %v, want %v", got, FrameDuration)
+	}
+	if s := p.ClockStats(); s.Resyncs != 1 {
+		t.Errorf("Resyncs = %d, want 1", s.Resyncs)
+	}
+}
+
+// --- Effects ---
+
+func TestEffectChainDefaults(t *testing.T) {
+	c := NewEffectChain()
+	var names []string
+	for _, s := range c.States() {
+		names = append(names, s.Name)
+		if s.Bypass != (s.Name == "tape") {
+			t.Errorf("%s bypass = %v", s.Name, s.Bypass)
+		}
+	}
+	if want := "eq width muffle tape"; strings.Join(names, " ") != want {
+		t.Errorf("Chain order = %v, want %s", names, want)
+	}
+
+	// Defaults are transparent outside a genre change.
+	src := Int16ToFloat(sine(3000, -12, 0.02))
+	frame := append([]float32(nil), src...)
+	c.Process(frame, FrameInfo{})
+	for i := range frame {
+		if frame[i] != src[i] {
+			t.Fatalf("Default chain changed sample %d: %v -> %v", i, src[i], frame[i])
+		}
+	}
+}
+
+func TestEffectChainSetParam(t *testing.T) {
+	c := NewEffectChain()
+	if err := c.SetParam("eq", "low", 3); err != nil {
+		t.Errorf("SetParam(eq, low, 3): %v", err)
+	}
+	if got := c.States()[0].Params["low"]; got != 3 {
+		t.Errorf("eq low = %v, want 3", got)
+	}
+	for _, bad := range []struct {
+		fx, param string
+		v         float64
+	}{
+		{"reverb", "size", 1},
+		{"eq", "presence", 1},
+		{"eq", "low", 20},
+		{"width", "width", math.NaN()},
+	} {
+		if err := c.SetParam(bad.fx, bad.param, bad.v); err == nil {
+			t.Errorf("SetParam(%s, %s, %v) should fail", bad.fx, bad.param, bad.v)
+		}
+	}
+	if err := c.SetBypass("reverb", true); err == nil {
+		t.Error("SetBypass on unknown effect should fail")
+	}
+}
+
+func TestEQBoostsBand(t *testing.T) {
+	eq := NewEQ()
+	eq.SetParam("low", 12)
+	src := Int16ToFloat(sine(60, -24, 0.5))
+	var peak float32
+	for i := 0; i+FrameSamples <= len(src); i += FrameSamples {
+		frame := append([]float32(nil), src[i:i+FrameSamples]...)
+		eq.Process(frame, FrameInfo{})
+		if i >= len(src)/2 {
+			for _, v := range frame {
+				peak = max(peak, v)
+			}
+		}
+	}
+	// -24 dBFS + ~12dB shelf at 60Hz, well below the 120Hz corner.
+	if db := 20 * math.Log10(float64(peak)); db < -14 || db > -11 {
+		t.Errorf("60Hz through +12dB low shelf peaks at %.1f dBFS, want about -12", db)
+	}
+}
+
+func TestStereoWidthMono(t *testing.T) {
+	w := NewStereoWidth()
+	w.SetParam("width", 0)
+	frame := []float32{0.8, 0.2, -0.5, 0.1}
+	w.Process(frame, FrameInfo{})
+	if frame[0] != frame[1] || frame[2] != frame[3] || frame[0] != 0.5 {
+		t.Errorf("Width 0 = %v, want mono [0.5 0.5 -0.2 -0.2]", frame)
+	}
+}
+
+func TestMuffleDuringGenreChange(t *testing.T) {
+	m := NewMuffle()
+	src := Int16ToFloat(sine(8000, -6, 0.2))
+	peakAt := func(amount float64) float32 {
+		var peak float32
+		for i := 0; i+FrameSamples <= len(src); i += FrameSamples {
+			frame := append([]float32(nil), src[i:i+FrameSamples]...)
+			m.Process(frame, FrameInfo{GenreChange: amount})
+			for _, v := range frame {
+				peak = max(peak, v)
+			}
+		}
+		return peak
+	}
+	var srcPeak float32
+	for _, v := range src {
+		srcPeak = max(srcPeak, v)
+	}
+	if p := peakAt(0); p != srcPeak {
+		t.Errorf("Muffle outside a genre change peak = %v, want untouched %v", p, srcPeak)
+	}
+	if p := peakAt(1); p > 0.05 {
+		t.Errorf("Muffle at transition midpoint peak = %v, want 8kHz heavily cut", p)
+	}
+}
+
+func TestTapeSaturationSoftClips(t *testing.T) {
+	tape := NewTapeSaturation()
+	frame := []float32{0.001, 2, -2}
+	tape.Process(frame, FrameInfo{})
+	if math.Abs(float64(frame[0])-0.001) > 1e-5 {
+		t.Errorf("Quiet sample = %v, want ~0.001", frame[0])
+	}
+	if frame[1] >= 1 || frame[2] <= -1 || frame[1] != -frame[2] {
+		t.Errorf("Overs = %v %v, want symmetric soft-clipped below full scale", frame[1], frame[2])
+	}
+}
+
+func TestPipelineAppliesEffects(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	p.Effects().SetParam("width", "width", 0)
+	p.sendFrame(context.Background(), []float32{1, 0, 0, 1})
+	got := <-p.frameCh
+	if got[0] != 0.5 || got[1] != 0.5 {
+		t.Errorf("Sent frame = %v, want effects applied", got)
+	}
+}
+
+// --- Standby bed ---
+
+func TestParseStandbyMode(t *testing.T) {
+	for _, m := range StandbyModes {
+		if got, err := ParseStandbyMode(string(m)); err != nil || got != m {
+			t.Errorf("ParseStandbyMode(%q) = %q, %v", m, got, err)
+		}
+	}
+	if _, err := ParseStandbyMode("jingle"); err == nil {
+		t.Error("ParseStandbyMode should reject unknown modes")
+	}
+}
+
+func TestNoiseSourceLevel(t *testing.T) {
+	n := newNoiseSource(1)
+	var sum float64
+	var count int
+	for i := 0; i < 200; i++ {
+		frame, ok := n.ReadFrame()
+		if !ok {
+			t.Fatal("Noise source ended")
+		}
+		for _, v := range frame {
+			sum += float64(v) * float64(v)
+			count++
+		}
+	}
+	if db := 10 * math.Log10(sum/float64(count)); db < -46 || db > -34 {
+		t.Errorf("Comfort noise at %.1f dBFS RMS, want about -40", db)
+	}
+}
+
+func TestLoopSourceLoops(t *testing.T) {
+	opens := 0
+	l := &loopSource{open: func() (frameSource, error) {
+		opens++
+		return &sliceSource{samples: make([]float32, 2*FrameSamples)}, nil
+	}}
+	for i := 0; i < 5; i++ {
+		if _, ok := l.ReadFrame(); !ok {
+			t.Fatalf("Loop ended at frame %d", i)
+		}
+	}
+	if opens != 3 {
+		t.Errorf("Opened %d times for 5 frames of a 2-frame loop, want 3", opens)
+	}
+
+	empty := &loopSource{open: func() (frameSource, error) { return &sliceSource{}, nil }}
+	if _, ok := empty.ReadFrame(); ok {
+		t.Error("Empty loop should fail instead of spinning")
+	}
+}
+
+func TestWaitTrackPlaysStandbyBed(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	p.SetStandby(StandbyConfig{Mode: StandbyNoise})
+
+	type result struct {
+		dt    *decodedTrack
+		start int
+	}
+	done := make(chan result, 1)
+	go func() {
+		dt, start, _ := p.waitTrack(context.Background())
+		done <- result{dt, start}
+	}()
+
+	// The bed keeps frames flowing while the queue is empty.
+	for i := 0; i < 20; i++ {
+		<-p.frameCh
+	}
+	if !p.InStandby() {
+		t.Error("InStandby = false while the bed is playing")
+	}
+
+	track := newTestTrack(make([]int16, 500*FrameSamples))
+	queueDecoded(p, track)
+	for {
+		select {
+		case <-p.frameCh:
+			continue
+		case r := <-done:
+			if r.dt != track || r.start != standbyFade {
+				t.Errorf("waitTrack = %p, %d; want the track after a %d-frame fade", r.dt, r.start, standbyFade)
+			}
+			if track.frames() != 500-standbyFade {
+				t.Errorf("Track has %d frames left, want %d", track.frames(), 500-standbyFade)
+			}
+			if p.InStandby() {
+				t.Error("InStandby = true after a track arrived")
+			}
+			return
+		}
+	}
+}
+
+func TestWaitTrackStandbyOff(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	p.SetStandby(StandbyConfig{Mode: StandbyOff})
+	track := newTestTrack(make([]int16, 10*FrameSamples))
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		queueDecoded(p, track)
+	}()
+	dt, start, ok := p.waitTrack(context.Background())
+	if !ok || dt != track || start != 0 {
+		t.Errorf("waitTrack = %p, %d, %v; want the track from frame 0", dt, start, ok)
+	}
+	if n := len(drain(p)); n != 0 {
+		t.Errorf("Standby off sent %d frames, want 0", n)
+	}
+}
+
+// --- Output monitor ---
+
+func TestMonitorLevels(t *testing.T) {
+	m := NewMonitor(MonitorConfig{SilenceDB: -60, ClipRatio: 0.01}, func() {})
+	src := Int16ToFloat(sine(441, -12, 1))
+	for i := 0; i+FrameSamples <= len(src); i += FrameSamples {
+		m.Observe(src[i : i+FrameSamples])
+	}
+	s := m.Stats()
+	if math.Abs(s.RMS-(-15)) > 0.2 || math.Abs(s.Peak-(-12)) > 0.2 {
+		t.Errorf("Sine at -12 dBFS: RMS %.1f, peak %.1f; want -15 / -12", s.RMS, s.Peak)
+	}
+	if s.ClipRatio != 0 || s.ClipEvents != 0 {
+		t.Errorf("Clean sine reported clipping: %+v", s)
+	}
+}
+
+func TestMonitorDetectsPlateaus(t *testing.T) {
+	m := NewMonitor(MonitorConfig{SilenceDB: -60, ClipRatio: 0.01}, func() {})
+	// A sine driven 12dB into a clipper, then scaled down by normalization.
+	src := Int16ToFloat(sine(441, 0, 1))
+	for i := 0; i+FrameSamples <= len(src); i += FrameSamples {
+		frame := make([]float32, FrameSamples)
+		for j := range frame {
+			frame[j] = 0.5 * max(-0.25, min(0.25, src[i+j])) / 0.25
+		}
+		m.Observe(frame)
+	}
+	if s := m.Stats(); s.ClipRatio < 0.3 || s.ClipEvents != 1 {
+		t.Errorf("Clipped sine: clip ratio %.2f, %d events; want heavy clipping, 1 event", s.ClipRatio, s.ClipEvents)
+	}
+}
+
+func TestMonitorSkipsDeadAir(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	p.Monitor().SetConfig(MonitorConfig{SilenceDB: -60, SilenceTimeout: 2 * time.Second, ClipRatio: 0.01})
+	ctx := context.Background()
+	silent := make([]float32, FrameSamples)
+
+	for i := 0; i < 99; i++ {
+		p.sendFrame(ctx, silent)
+		<-p.frameCh
+	}
+	if n := p.Monitor().Stats().DeadAir; n != 0 {
+		t.Fatalf("Dead air after 1.98s = %d, want 0", n)
+	}
+	p.sendFrame(ctx, silent)
+	<-p.frameCh
+	if n := p.Monitor().Stats().DeadAir; n != 1 {
+		t.Errorf("Dead air after 2s = %d, want 1", n)
+	}
+	if p.sendFrame(ctx, silent) {
+		t.Error("Dead air did not skip the track")
+	}
+
+	// Disabled: silence is reported in the levels but never skipped.
+	p.Monitor().SetConfig(MonitorConfig{SilenceDB: -60, ClipRatio: 0.01})
+	for i := 0; i < 200; i++ {
+		if !p.sendFrame(ctx, silent) {
+			t.Fatal("Skipped with dead-air timeout disabled")
+		}
+		<-p.frameCh
+	}
+}
+
+func TestDeadAirIgnoresMasterGainAndAmbient(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	p.Monitor().SetConfig(MonitorConfig{SilenceDB: -60, SilenceTimeout: time.Second, ClipRatio: 0.01})
+	ctx := context.Background()
+
+	// Music at -30 dBFS under a -40 dB master gain goes out near -70 dBFS.
+	p.SetMasterGain(-40)
+	src := Int16ToFloat(sine(441, -27, 3))
+	for i := 0; i+FrameSamples <= len(src); i += FrameSamples {
+		frame := make([]float32, FrameSamples)
+		copy(frame, src[i:])
+		if !p.sendFrame(ctx, frame) {
+			t.Fatal("Quiet master gain skipped a playing track")
+		}
+		<-p.frameCh
+	}
+	if s := p.Monitor().Stats(); s.DeadAir != 0 || s.RMS > -60 {
+		t.Errorf("Monitor = %+v, want output below -60 dBFS and no dead air", s)
+	}
+
+	// An ambient bed over silent music doesn't hide the dead air.
+	p.SetMasterGain(0)
+	p.ambient.loop = sine(300, -20, 1)
+	p.ambient.state = AmbientState{Enabled: true}
+	p.ambient.level = 1 // skip the fade-in
+	silent := 0
+	for ; silent < 100 && p.Monitor().Stats().DeadAir == 0; silent++ {
+		p.sendFrame(ctx, make([]float32, FrameSamples))
+		drain(p)
+	}
+	if s := p.Monitor().Stats(); s.DeadAir != 1 || s.RMS < -40 {
+		t.Errorf("Monitor = %+v after %d silent frames under ambient, want dead air with the bed audible", s, silent)
+	}
+}
+
+// --- Features ---
+
+// chords renders each chord (MIDI notes) for secs seconds, with a couple
+// of harmonics so it looks more like an instrument than pure sines.
+func chords(secs float64, progression ...[]int) []float32 {
+	n := int(secs * SampleRate)
+	var samples []float32
+	for _, chord := range progression {
+		for i := 0; i < n; i++ {
+			var v float64
+			for _, note := range chord {
+				f := 440 * math.Pow(2, float64(note-69)/12)
+				for h := 1; h <= 3; h++ {
+					v += math.Sin(2*math.Pi*f*float64(h)*float64(i)/SampleRate) / float64(h)
+				}
+			}
+			x := float32(v * 0.05)
+			samples = append(samples, x, x)
+		}
+	}
+	return samples
+}
+
+func TestFFTSine(t *testing.T) {
+	re, im := make([]float64, 64), make([]float64, 64)
+	for i := range re {
+		re[i] = math.Cos(2 * math.Pi * 5 * float64(i) / 64)
+	}
+	fft(re, im)
+	for k := 0; k < 32; k++ {
+		mag := math.Hypot(re[k], im[k])
+		if want := map[bool]float64{true: 32, false: 0}[k == 5]; math.Abs(mag-want) > 1e-9 {
+			t.Errorf("Bin %d magnitude = %v, want %v", k, mag, want)
+		}
+	}
+}
+
+func TestDetectKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		progression [][]int
+		want        string
+	}{
+		// I-IV-V-I in C major
+		{"C major", [][]int{{60, 64, 67}, {65, 69, 72}, {67, 71, 74}, {60, 64, 67}}, "C major"},
+		// i-iv-V-i in A minor
+		{"A minor", [][]int{{57, 60, 64}, {62, 65, 69}, {64, 68, 71}, {57, 60, 64}}, "A minor"},
+		// I-IV-V-I in D major
+		{"D major", [][]int{{62, 66, 69}, {67, 71, 74}, {69, 73, 76}, {62, 66, 69}}, "D major"},
+	}
+	for _, tt := range tests {
+		s := newSpectrumAnalyzer()
+		s.Write(chords(1, tt.progression...))
+		if key, score := s.Key(); key.String() != tt.want {
+			t.Errorf("%s: detected %q (score %.2f)", tt.name, key, score)
+		}
+	}
+
+	s := newSpectrumAnalyzer()
+	s.Write(make([]float32, SampleRate*Channels))
+	if key, _ := s.Key(); key.Known() {
+		t.Errorf("Silence detected as %q, want unknown", key)
+	}
+}
+
+func TestSpectralCentroid(t *testing.T) {
+	dark, bright := newSpectrumAnalyzer(), newSpectrumAnalyzer()
+	dark.Write(Int16ToFloat(sine(200, -12, 1)))
+	bright.Write(Int16ToFloat(sine(5000, -12, 1)))
+	if c := dark.Centroid(); math.Abs(c-200) > 20 {
+		t.Errorf("200Hz centroid = %.0f", c)
+	}
+	if c := bright.Centroid(); math.Abs(c-5000) > 100 {
+		t.Errorf("5kHz centroid = %.0f", c)
+	}
+}
+
+func TestEnergyCurve(t *testing.T) {
+	levels := make([]float32, 250) // 2.5s of 10ms windows
+	for i := range levels {
+		levels[i] = 0.1
+		if i >= 100 {
+			levels[i] = 0.2
+		}
+	}
+	curve := energyCurve(levels, 6.0206) // x2
+	if len(curve) != 3 {
+		t.Fatalf("Curve has %d points, want 3 (one per started second)", len(curve))
+	}
+	for i, want := range []float32{0.2, 0.4, 0.4} {
+		if math.Abs(float64(curve[i]-want)) > 1e-3 {
+			t.Errorf("Curve[%d] = %v, want %v", i, curve[i], want)
+		}
+	}
+}
+
+// --- Harmonic mixing ---
+
+func TestCamelot(t *testing.T) {
+	tests := []struct {
+		key  Key
+		want string
+	}{
+		{Key{0, Major}, "8B"},  // C
+		{Key{7, Major}, "9B"},  // G
+		{Key{5, Major}, "7B"},  // F
+		{Key{11, Major}, "1B"}, // B
+		{Key{9, Minor}, "8A"},  // A minor
+		{Key{4, Minor}, "9A"},  // E minor
+		{Key{8, Minor}, "1A"},  // Ab minor
+		{Key{}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.key.Camelot(); got != tt.want {
+			t.Errorf("%v.Camelot() = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+	if d := harmonicDistance(Key{0, Major}, Key{9, Minor}); d != 1 {
+		t.Errorf("C major -> A minor distance = %d, want 1 (relative keys)", d)
+	}
+	if d := harmonicDistance(Key{11, Major}, Key{6, Major}); d != 1 {
+		t.Errorf("B -> F# distance = %d, want 1 (wraps 1B -> 2B)", d)
+	}
+	if d := harmonicDistance(Key{0, Major}, Key{6, Major}); d != 6 {
+		t.Errorf("C -> F# distance = %d, want 6", d)
+	}
+	if d := tempoDistance(120, 60); d > 1e-9 {
+		t.Errorf("120 -> 60 BPM distance = %v, want 0 (half time)", d)
+	}
+}
+
+// queueDecoded adds dt to the queue as if the decoder had just opened it.
+func queueDecoded(p *Pipeline, dt *decodedTrack) {
+	p.queue.add(dt.info)
+	p.queue.mu.Lock()
+	pending := p.queue.items[len(p.queue.items)-1]
+	p.queue.mu.Unlock()
+	p.queue.ready(pending, dt)
+}
+
+func keyedTrack(id, genre string, key Key, bpm float64) *decodedTrack {
+	dt := newTestTrack(make([]int16, FrameSamples))
+	dt.info = TrackInfo{ID: id, Genre: genre, Key: key, BPM: bpm}
+	return dt
+}
+
+func TestTrackQueueOrder(t *testing.T) {
+	current := TrackInfo{Genre: "lofi", Key: Key{0, Major}, BPM: 90}
+	fill := func(p *Pipeline) {
+		queueDecoded(p, keyedTrack("clash", "lofi", Key{6, Major}, 90))
+		queueDecoded(p, keyedTrack("match", "lofi", Key{9, Minor}, 90))
+	}
+
+	p, _ := newTestPipeline(0)
+	fill(p)
+	if dt, _ := p.nextTrack(current); dt.info.ID != "clash" {
+		t.Errorf("FIFO picked %s, want clash", dt.info.ID)
+	}
+
+	p, _ = newTestPipeline(0)
+	p.SetHarmonicMix(true)
+	fill(p)
+	if dt, _ := p.nextTrack(current); dt.info.ID != "match" {
+		t.Errorf("Harmonic mix picked %s, want match", dt.info.ID)
+	}
+	if p.QueueSize() != 1 {
+		t.Errorf("QueueSize = %d, want 1", p.QueueSize())
+	}
+}
+
+func TestTrackQueueGenreAndStarvation(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	p.SetHarmonicMix(true)
+	current := TrackInfo{Genre: "lofi", Key: Key{0, Major}, BPM: 90}
+
+	// A better match in the next genre doesn't jump the genre change.
+	queueDecoded(p, keyedTrack("lofi", "lofi", Key{6, Major}, 90))
+	queueDecoded(p, keyedTrack("jazz", "jazz", Key{0, Major}, 90))
+	if dt, _ := p.nextTrack(current); dt.info.ID != "lofi" {
+		t.Errorf("Picked %s across a genre change, want lofi", dt.info.ID)
+	}
+	p.nextTrack(current)
+
+	// Nor does one queued after it.
+	queueDecoded(p, keyedTrack("lofi", "lofi", Key{6, Major}, 90))
+	queueDecoded(p, keyedTrack("jazz", "jazz", Key{0, Major}, 90))
+	queueDecoded(p, keyedTrack("lofi-match", "lofi", Key{0, Major}, 90))
+	for _, want := range []string{"lofi", "jazz", "lofi-match"} {
+		if dt, _ := p.nextTrack(current); dt.info.ID != want {
+			t.Errorf("Picked %s, want %s with the genre change keeping its place", dt.info.ID, want)
+		}
+	}
+
+	// A clashing track is passed over at most maxPassOver times.
+	queueDecoded(p, keyedTrack("clash", "lofi", Key{6, Major}, 90))
+	for i := 0; i < maxPassOver; i++ {
+		queueDecoded(p, keyedTrack("match", "lofi", Key{0, Major}, 90))
+		if dt, _ := p.nextTrack(current); dt.info.ID != "match" {
+			t.Fatalf("Pick %d = %s, want match", i, dt.info.ID)
+		}
+	}
+	queueDecoded(p, keyedTrack("match", "lofi", Key{0, Major}, 90))
+	if dt, _ := p.nextTrack(current); dt.info.ID != "clash" {
+		t.Errorf("Picked %s after %d pass-overs, want clash", dt.info.ID, maxPassOver)
+	}
+}
+
+// --- Queue editing ---
+
+func queueIDs(p *Pipeline) string {
+	var ids []string
+	for _, e := range p.Queue() {
+		id := e.Track.ID
+		if e.Decoded {
+			id += "*"
+		}
+		if e.Pinned {
+			id += "!"
+		}
+		ids = append(ids, id)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestQueueEdit(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	a, b := keyedTrack("a", "lofi", Key{}, 0), keyedTrack("b", "lofi", Key{}, 0)
+	queueDecoded(p, a)
+	queueDecoded(p, b)
+	p.Enqueue(TrackInfo{ID: "c", Caption: "warm tape"})
+	p.Enqueue(TrackInfo{ID: "d"})
+
+	if got := queueIDs(p); got != "a* b* c d" {
+		t.Fatalf("Queue = %q, want decoded a, b then pending c, d", got)
+	}
+	if q := p.Queue(); q[2].Track.Caption != "warm tape" {
+		t.Errorf("Caption = %q, want it carried through the queue", q[2].Track.Caption)
+	}
+
+	if err := p.PlayNext("d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PinQueued("a", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RemoveQueued("b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := queueIDs(p); got != "d a*! c" {
+		t.Errorf("Queue = %q, want d a*! c", got)
+	}
+	if !b.src.(*sliceSource).closed {
+		t.Error("Removed track's stream not closed")
+	}
+	if err := p.RemoveQueued("b"); err == nil {
+		t.Error("Removing a missing track succeeded")
+	}
+
+	// The moved track decodes next; the pending head doesn't hold up a.
+	if pending, _ := p.queue.nextPending(context.Background()); pending.info.ID != "d" {
+		t.Errorf("Next to decode = %s, want d", pending.info.ID)
+	}
+	if dt, _ := p.nextTrack(TrackInfo{}); dt != a {
+		t.Errorf("Next track = %v, want a", dt)
+	}
+}
+
+func TestQueuePinHoldsOrder(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	p.Enqueue(TrackInfo{ID: "a"})
+	queueDecoded(p, keyedTrack("b", "lofi", Key{}, 0))
+	if dt, _ := p.nextTrack(TrackInfo{}); dt == nil || dt.info.ID != "b" {
+		t.Fatalf("Next track = %v, want b past the pending head", dt)
+	}
+
+	queueDecoded(p, keyedTrack("c", "lofi", Key{}, 0))
+	if err := p.PinQueued("a", true); err != nil {
+		t.Fatal(err)
+	}
+	if dt, _ := p.nextTrack(TrackInfo{}); dt != nil {
+		t.Errorf("Next track = %s, want none until pinned a is decoded", dt.info.ID)
+	}
+}
+
+func TestQueuePinnedHeadDecodesAtCapacity(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	for i := 0; i < p.queue.capacity; i++ {
+		queueDecoded(p, keyedTrack(string(rune('b'+i)), "lofi", Key{}, 0))
+	}
+	p.Enqueue(TrackInfo{ID: "a"})
+	if err := p.PlayNext("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PinQueued("a", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The full queue can't play past a, so a decodes anyway.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pending, ok := p.queue.nextPending(ctx)
+	if !ok || pending.info.ID != "a" {
+		t.Fatalf("Next to decode = %v, want pinned a despite a full queue", pending)
+	}
+	p.queue.ready(pending, keyedTrack("a", "lofi", Key{}, 0))
+	if dt, _ := p.nextTrack(TrackInfo{}); dt == nil || dt.info.ID != "a" {
+		t.Errorf("Next track = %v, want a", dt)
+	}
+
+	// Unpinned, a pending entry waits for room as before.
+	p.Enqueue(TrackInfo{ID: "z"})
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if pending, ok := p.queue.nextPending(ctx); ok {
+		t.Errorf("Next to decode = %s, want none with the queue full", pending.info.ID)
+	}
+
+	// Pinned behind a decoded entry, it waits too: the player can still
+	// take b, which frees a place.
+	if err := p.PinQueued("z", true); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if pending, ok := p.queue.nextPending(ctx); ok {
+		t.Errorf("Next to decode = %s, want none with b playable ahead of pinned z", pending.info.ID)
+	}
+}
+
+func TestQueueRemoveWhileDecoding(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	p.Enqueue(TrackInfo{ID: "a"})
+	pending, _ := p.queue.nextPending(context.Background())
+	if err := p.RemoveQueued("a"); err != nil {
+		t.Fatal(err)
+	}
+	if p.queue.ready(pending, newTestTrack(make([]int16, FrameSamples))) {
+		t.Error("ready = true for a track removed while decoding")
+	}
+	if p.QueueSize() != 0 {
+		t.Errorf("QueueSize = %d, want 0", p.QueueSize())
+	}
+}
+
+// --- History ---
+
+func TestHistoryRing(t *testing.T) {
+	p, clk := newTestPipeline(0)
+	if err := p.Previous(); err == nil {
+		t.Error("Previous with an empty history succeeded")
+	}
+	start := clk.Now()
+	for i := 0; i < historySize+5; i++ {
+		id := string(rune('a'+i%26)) + strings.Repeat("x", i/26)
+		p.setTrack(TrackInfo{ID: id, Path: "/out/" + id + ".flac"}, 100)
+		p.updatePosition(49)
+		p.recordHistory()
+		clk.Advance(time.Second)
+	}
+
+	h := p.History()
+	if len(h) != historySize {
+		t.Fatalf("History has %d entries, want %d", len(h), historySize)
+	}
+	if h[0].Track.ID != "cxx" || !h[0].PlayedAt.Equal(start.Add(time.Duration(historySize+4)*time.Second)) {
+		t.Errorf("Newest entry = %s at %v, want cxx at +%ds", h[0].Track.ID, h[0].PlayedAt.Sub(start), historySize+4)
+	}
+	if h[0].Aired != time.Second {
+		t.Errorf("Aired = %v, want 1s", h[0].Aired)
+	}
+	for i, e := range h {
+		if want := i < historyReplayable; e.Replayable != want || (e.Track.Path != "") != want {
+			t.Errorf("Entry %d replayable = %v (path %q), want %v", i, e.Replayable, e.Track.Path, want)
+		}
+	}
+	if err := p.Replay(h[historyReplayable].Track.ID); err == nil {
+		t.Error("Replayed a track past the replayable window")
+	}
+}
+
+func TestHistoryPrevious(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	for _, id := range []string{"a", "b"} {
+		p.setTrack(TrackInfo{ID: id, Genre: "jazz", Path: "/out/" + id + ".flac", BPM: 120}, 100)
+		p.recordHistory()
+	}
+	p.Enqueue(TrackInfo{ID: "c"})
+
+	if err := p.Previous(); err != nil {
+		t.Fatal(err)
+	}
+	if got := queueIDs(p); got != "b! c" {
+		t.Errorf("Queue = %q, want pinned b ahead of c", got)
+	}
+	if q := p.Queue(); q[0].Track.Path != "/out/b.flac" || q[0].Track.BPM != 0 {
+		t.Errorf("Replayed entry = %+v, want the path without stale analysis", q[0].Track)
+	}
+	select {
+	case <-p.skipCh:
+	default:
+		t.Error("Previous didn't skip the current track")
+	}
+
+	if err := p.Replay("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := queueIDs(p); got != "a! b! c" {
+		t.Errorf("Queue = %q, want a! b! c", got)
+	}
+}
+
+// --- Pause ---
+
+func constFrame(v float32) []float32 {
+	f := make([]float32, FrameSamples)
+	for i := range f {
+		f[i] = v
+	}
+	return f
+}
+
+func TestPauseResume(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	p.Monitor().SetConfig(MonitorConfig{SilenceDB: -60, SilenceTimeout: 100 * time.Millisecond, ClipRatio: 0.01})
+	ctx := context.Background()
+	fadeFrames := int(pauseFade / FrameDuration)
+
+	p.Pause()
+	for i := 0; i < fadeFrames; i++ {
+		p.sendFrame(ctx, constFrame(0.5))
+	}
+	frames := drain(p)
+	first, last := frames[0], frames[fadeFrames-1]
+	if first[0] < 0.49 || last[0] > 0.49 || last[FrameSamples-1] > 1e-6 {
+		t.Errorf("Fade out went %v -> %v -> %v, want 0.5 down to 0", first[0], last[0], last[FrameSamples-1])
+	}
+
+	// Faded out: the next frame is held while silence keeps flowing.
+	held := make(chan bool)
+	go func() { held <- p.sendFrame(ctx, constFrame(0.5)) }()
+	for i := 0; i < 20; i++ {
+		for _, x := range <-p.frameCh {
+			if x != 0 {
+				t.Fatalf("Paused frame %d has sample %v, want silence", i, x)
+			}
+		}
+	}
+	select {
+	case <-p.skipCh:
+		t.Error("Dead-air monitor skipped a paused station")
+	default:
+	}
+
+	p.Resume()
+	if !<-held {
+		t.Fatal("Held frame not sent after resume")
+	}
+	sent := drain(p)
+	in := sent[len(sent)-1]
+	if in[0] > 0.05 || in[FrameSamples-1] < 0.01 {
+		t.Errorf("Held frame starts %v, ends %v; want a fade in from silence", in[0], in[FrameSamples-1])
+	}
+	for i := 0; i < fadeFrames; i++ {
+		p.sendFrame(ctx, constFrame(0.5))
+	}
+	if f := drain(p); f[fadeFrames-1][FrameSamples-1] < 0.49 {
+		t.Errorf("Level after fade in = %v, want 0.5", f[fadeFrames-1][FrameSamples-1])
+	}
+}
+
+// --- Master gain ---
+
+func TestMasterGainRamp(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	ctx := context.Background()
+	p.SetMasterGain(-6.0206) // x0.5
+	p.sendFrame(ctx, constFrame(0.5))
+	p.sendFrame(ctx, constFrame(0.5))
+	p.sendFrame(ctx, constFrame(0.5))
+	f := drain(p)
+	// 0.5 of full scale takes 25ms: just over one frame.
+	if f[0][0] < 0.49 || f[0][FrameSamples-1] > 0.3 || math.Abs(float64(f[2][0])-0.25) > 1e-3 {
+		t.Errorf("Ramp went %v -> %v -> %v, want 0.5 down to 0.25", f[0][0], f[0][FrameSamples-1], f[2][0])
+	}
+	for i := 2; i < FrameSamples; i += 2 {
+		if d := math.Abs(float64(f[0][i] - f[0][i-2])); d > 0.5/(masterRamp.Seconds()*SampleRate)+1e-6 {
+			t.Fatalf("Step of %v at sample %d, want a smooth ramp", d, i/2)
+		}
+	}
+
+	p.SetMute(true)
+	for i := 0; i < 3; i++ {
+		p.sendFrame(ctx, constFrame(0.5))
+	}
+	if f := drain(p); f[2][0] != 0 || f[0][0] == 0 {
+		t.Errorf("Mute went %v -> %v, want a ramp to silence", f[0][0], f[2][0])
+	}
+	p.SetMute(false)
+
+	p.SetMasterGain(40)
+	if g := p.MasterGain(); g != MaxMasterGain {
+		t.Errorf("MasterGain = %v, want clamped to %v", g, MaxMasterGain)
+	}
+}
+
+// --- Ambient overlay ---
+
+func TestSeamLoop(t *testing.T) {
+	// A ramp 0..999 per channel: the seam should run from the blended
+	// tail into the head without a jump.
+	samples := make([]int16, 1000*Channels)
+	for i := range samples {
+		samples[i] = int16(i / Channels * 10)
+	}
+	loop := seamLoop(samples, 100)
+	if len(loop) != 900*Channels {
+		t.Fatalf("Loop has %d samples, want %d", len(loop), 900*Channels)
+	}
+	// Loop start is mostly tail (9000 region), easing into the head.
+	if loop[0] < 8900 || loop[99*Channels] > 1100 {
+		t.Errorf("Seam runs %d ... %d, want tail easing into head", loop[0], loop[99*Channels])
+	}
+	if d := int(loop[0]) - int(loop[len(loop)-Channels]); d < 0 || d > 200 {
+		t.Errorf("Loop wraps from %d to %d, want continuous", loop[len(loop)-Channels], loop[0])
+	}
+}
+
+func TestAmbientMix(t *testing.T) {
+	a := NewAmbient()
+	loop := make([]int16, SampleRate*Channels)
+	for i := range loop {
+		loop[i] = 16384 // 0.5
+	}
+	if err := a.load("rain.flac", loop); err != nil {
+		t.Fatal(err)
+	}
+	a.SetGain(-6.0206) // x0.5
+	a.SetEnabled(true)
+
+	fadeFrames := int(ambientFade / FrameDuration)
+	var f []float32
+	for i := 0; i <= fadeFrames+1; i++ {
+		f = make([]float32, FrameSamples)
+		a.mix(f, false)
+		if i == 1 && (f[0] <= 0 || f[0] > 0.01) {
+			t.Errorf("First mixed sample = %v, want a fade in from silence", f[0])
+		}
+	}
+	if math.Abs(float64(f[0])-0.25) > 1e-3 {
+		t.Errorf("Level after fade = %v, want 0.25", f[0])
+	}
+
+	// Switching files fades the old loop out before the new one starts.
+	quiet := make([]int16, SampleRate*Channels)
+	if err := a.load("cafe.flac", quiet); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < fadeFrames+2; i++ {
+		f = make([]float32, FrameSamples)
+		a.mix(f, false)
+	}
+	if a.State().File != "cafe.flac" || a.pending != nil {
+		t.Errorf("State = %+v, pending %v; want the cafe loop swapped in", a.State(), a.pending != nil)
+	}
+
+	// Holding (paused) fades out completely.
+	for i := 0; i < 3*fadeFrames; i++ {
+		a.mix(make([]float32, FrameSamples), true)
+	}
+	if a.level != 0 {
+		t.Errorf("Level while held = %v, want 0", a.level)
+	}
+}
+
+func TestAmbientFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"rain.flac", "vinyl.WAV", "notes.txt"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+	}
+	a := NewAmbient()
+	if files, err := a.Files(); err != nil || files != nil {
+		t.Errorf("Files with no dir = %v, %v; want none", files, err)
+	}
+	a.SetDir(dir)
+	files, err := a.Files()
+	if err != nil || strings.Join(files, ",") != "rain.flac,vinyl.WAV" {
+		t.Errorf("Files = %v, %v; want the audio files", files, err)
+	}
+	if err := a.SetFile("../etc/passwd"); err == nil {
+		t.Error("SetFile accepted a path outside the ambient dir")
+	}
+	if err := a.load("short.wav", make([]int16, 100)); err == nil {
+		t.Error("load accepted a loop shorter than its seam")
+	}
+}
+
+// --- Jingles ---
+
+func TestParseJingleRules(t *testing.T) {
+	rules, err := ParseJingleRules("*=4, jazz=20m:overlay, ambient=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]JingleRule{
+		"*":       {EveryTracks: 4, Mode: JingleBetween},
+		"jazz":    {Every: 20 * time.Minute, Mode: JingleOverlay},
+		"ambient": {Mode: JingleBetween},
+	}
+	for genre, w := range want {
+		if rules[genre] != w {
+			t.Errorf("Rule %q = %+v, want %+v", genre, rules[genre], w)
+		}
+		if r, err := ParseJingleRule(w.String()); err != nil || r != w {
+			t.Errorf("ParseJingleRule(%q) = %+v, %v; want round trip", w.String(), r, err)
+		}
+	}
+	for _, bad := range []string{"jazz", "jazz=4:loud", "jazz=often", "jazz=-2"} {
+		if _, err := ParseJingleRules(bad); err == nil {
+			t.Errorf("ParseJingleRules(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestJingleSchedule(t *testing.T) {
+	j := jingleScheduler{dir: "/jingles", rules: map[string]JingleRule{
+		"*":    {EveryTracks: 3, Mode: JingleBetween},
+		"jazz": {Every: 10 * time.Minute, Mode: JingleOverlay},
+		"rock": {},
+	}}
+	now := time.Unix(0, 0)
+	var got []bool
+	for i := 0; i < 6; i++ {
+		_, due := j.due("lofi", now)
+		got = append(got, due)
+	}
+	if fmt.Sprint(got) != "[false false true false false true]" {
+		t.Errorf("Every 3 tracks: due %v", got)
+	}
+	if _, due := j.due("rock", now); due {
+		t.Error("Zero rule played a jingle")
+	}
+	if _, due := j.due("jazz", now.Add(9*time.Minute)); due {
+		t.Error("Jingle due before 10 minutes")
+	}
+	if r, due := j.due("jazz", now.Add(10*time.Minute)); !due || r.Mode != JingleOverlay {
+		t.Errorf("due at 10 minutes = %+v, %v; want overlay", r, due)
+	}
+	j.dir = ""
+	for i := 0; i < 6; i++ {
+		if _, due := j.due("lofi", now); due {
+			t.Fatal("Jingle due with no jingle dir")
+		}
+	}
+}
+
+func TestJingleBetweenTracks(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"id-one.wav", "id-two.wav"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+	}
+	p, _ := newTestPipeline(0)
+	p.SetHarmonicMix(true)
+	p.SetJingleDir(dir)
+	p.SetJingleRules(map[string]JingleRule{"*": {EveryTracks: 1, Mode: JingleBetween}})
+	queueDecoded(p, keyedTrack("next", "lofi", Key{0, Major}, 90))
+
+	p.scheduleJingle(context.Background(), "lofi")
+	q := p.Queue()
+	if len(q) != 2 || !q[0].Track.Interstitial || q[0].Track.Genre != "lofi" {
+		t.Fatalf("Queue = %+v, want a lofi jingle ahead of next", q)
+	}
+	if p.QueueSize() != 1 {
+		t.Errorf("QueueSize = %d, want 1 (jingles not counted)", p.QueueSize())
+	}
+	p.scheduleJingle(context.Background(), "lofi")
+	if q := p.Queue(); q[0].Track.Name == q[1].Track.Name {
+		t.Errorf("Same jingle %q queued back to back", q[0].Track.Name)
+	}
+
+	// Harmonic mixing never jumps a jingle at the head once decoded.
+	head := p.Queue()[0].Track
+	p.queue.mu.Lock()
+	p.queue.items[0] = keyedTrack(head.ID, "lofi", Key{}, 0)
+	p.queue.items[0].info.Interstitial = true
+	p.queue.mu.Unlock()
+	if dt, _ := p.nextTrack(TrackInfo{Key: Key{0, Major}, BPM: 90}); dt == nil || !dt.info.Interstitial {
+		t.Errorf("Next track = %v, want the jingle", dt)
+	}
+
+	// Jingles stay out of the history.
+	p.setTrack(TrackInfo{ID: "jingle-1", Interstitial: true}, 10)
+	p.recordHistory()
+	if h := p.History(); len(h) != 0 {
+		t.Errorf("History = %+v, want no jingles", h)
+	}
+}
+
+func TestJingleOverlayDucks(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	jingle := make([]int16, 50*FrameSamples)
+	for i := range jingle {
+		jingle[i] = 3277 // 0.1
+	}
+	p.overlay = newTestTrack(jingle)
+
+	ramp := int(jingleDuckRamp/FrameDuration) + 1
+	var f []float32
+	for i := 0; i <= ramp; i++ {
+		f = constFrame(0.5)
+		p.mixOverlay(f)
+	}
+	if want := 0.5*jingleDuck + 0.1; math.Abs(float64(f[0])-want) > 1e-3 {
+		t.Errorf("Ducked mix = %v, want %v", f[0], want)
+	}
+	for p.overlay != nil {
+		p.mixOverlay(constFrame(0.5))
+	}
+	for i := 0; i <= ramp; i++ {
+		f = constFrame(0.5)
+		p.mixOverlay(f)
+	}
+	if p.duck != 1 || f[FrameSamples-1] != 0.5 {
+		t.Errorf("After the jingle: duck %v, level %v; want released to 0.5", p.duck, f[FrameSamples-1])
+	}
+}
+
+func TestAnnouncement(t *testing.T) {
+	tests := []struct {
+		prev  TrackInfo
+		genre string
+		want  string
+	}{
+		{TrackInfo{Name: "Night Drive"}, "jazz", "That was Night Drive, up next some jazz."},
+		{TrackInfo{Name: "Night Drive"}, "", "That was Night Drive."},
+		{TrackInfo{}, "jazz", "Up next some jazz."},
+	}
+	for _, tt := range tests {
+		if got := announcement(tt.prev, tt.genre); got != tt.want {
+			t.Errorf("announcement(%q, %q) = %q, want %q", tt.prev.Name, tt.genre, got, tt.want)
+		}
+	}
+}
+
+func TestExecTTS(t *testing.T) {
+	dir := t.TempDir()
+
+	// Text on stdin, written to {out}.
+	tts := &ExecTTS{Args: []string{"sh", "-c", `cat > "$0"`, "{out}"}}
+	out := filepath.Join(dir, "stdin.txt")
+	if err := tts.Synthesize(context.Background(), "hello there", out); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "hello there\n" {
+		t.Errorf("stdin output = %q", data)
+	}
+
+	// No {out}: stdout is saved.
+	tts, err := NewExecTTS("echo {text}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = filepath.Join(dir, "stdout.txt")
+	if err := tts.Synthesize(context.Background(), "up next", out); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "up next\n" {
+		t.Errorf("stdout output = %q", data)
+	}
+
+	if _, err := NewExecTTS("  "); err == nil {
+		t.Error("Empty command accepted")
+	}
+	if err := (&ExecTTS{Args: []string{"false"}}).Synthesize(context.Background(), "x", out); err == nil {
+		t.Error("Failing command accepted")
+	}
+}
+
+func TestVoiceSidechain(t *testing.T) {
+	p, _ := newTestPipeline(0)
+	hold := int(overlayHold / FrameDuration)
+	ramp := int(jingleDuckRamp/FrameDuration) + 1
+
+	// Speech, a gap shorter than the hold, more speech, then a long pause.
+	var voice []int16
+	for _, seg := range []struct {
+		frames int
+		level  int16
+	}{{ramp, 3277}, {hold / 2, 0}, {ramp, 3277}, {hold + ramp, 0}, {1, 3277}} {
+		for i := 0; i < seg.frames*FrameSamples; i++ {
+			voice = append(voice, seg.level)
+		}
+	}
+	vo := newTestTrack(voice)
+	p.queueVoice(vo)
+	p.overlay = <-p.voiceCh
+
+	duck := func(frames int) float64 {
+		for i := 0; i < frames; i++ {
+			p.mixOverlay(constFrame(0.5))
+		}
+		return p.duck
+	}
+	if d := duck(ramp); d != voiceDuck {
+		t.Errorf("Duck while speaking = %v, want %v", d, voiceDuck)
+	}
+	if d := duck(hold / 2); d != voiceDuck {
+		t.Errorf("Duck over a short gap = %v, want held at %v", d, voiceDuck)
+	}
+	if d := duck(ramp + hold + ramp); d != 1 {
+		t.Errorf("Duck after a long pause = %v, want released", d)
+	}
+
+	// A newer announcement replaces one still waiting.
+	a, b := newTestTrack(voice), newTestTrack(voice)
+	p.queueVoice(a)
+	p.queueVoice(b)
+	if got := <-p.voiceCh; got != b || !a.src.(*sliceSource).closed {
+		t.Error("Waiting announcement not replaced by the newer one")
+	}
+}
+
+func TestWaveform(t *testing.T) {
+	// 1s of silence then 1s at 0.5 peak (a square wave, so RMS is 0.5 too).
+	samples := make([]int16, 2*SampleRate*Channels)
+	for i := SampleRate * Channels; i < len(samples); i++ {
+		samples[i] = 16384
+		if (i/Channels)%2 == 1 {
+			samples[i] = -16384
+		}
+	}
+	a := analyze(&sliceSource{samples: Int16ToFloat(samples)})
+
+	w := waveform(a.peaks, a.levels, a.Samples, 0, 4)
+	if w.Duration != 2*time.Second || len(w.Peaks) != 4 || w.BucketDuration() != 500*time.Millisecond {
+		t.Fatalf("Waveform = %v over %d buckets, want 2s over 4", w.Duration, len(w.Peaks))
+	}
+	for b, want := range []float32{0, 0, 0.5, 0.5} {
+		if math.Abs(float64(w.Peaks[b]-want)) > 1e-3 || math.Abs(float64(w.RMS[b]-want)) > 1e-3 {
+			t.Errorf("Bucket %d: peak %v, RMS %v; want %v", b, w.Peaks[b], w.RMS[b], want)
+		}
+	}
+
+	// Normalization gain is applied; fewer windows than buckets caps the count.
+	w = waveform(a.peaks, a.levels, a.Samples, 6, waveformBuckets)
+	if len(w.Peaks) != len(a.peaks) || math.Abs(float64(w.Peaks[len(w.Peaks)-1])-0.998) > 1e-2 {
+		t.Errorf("Waveform +6dB: %d buckets, last peak %v; want %d, ~1", len(w.Peaks), w.Peaks[len(w.Peaks)-1], len(a.peaks))
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	p, _ := newTestPipeline(4 * time.Second)
+	p.setTrack(TrackInfo{ID: "now", TrimStart: time.Second, TrimEnd: 60 * time.Second, FadeIn: 2 * time.Second, FadeOut: 5 * time.Second}, 10)
+	queued := keyedTrack("queued", "lofi", Key{}, 0)
+	queued.info.TrimStart, queued.info.TrimEnd = 0, 6*time.Second
+	queueDecoded(p, queued)
+	p.Enqueue(TrackInfo{ID: "pending"})
+
+	tl, err := p.Timeline("now")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tl.Estimated || tl.Aired != (Region{time.Second, 60 * time.Second}) ||
+		tl.CrossfadeIn != (Region{time.Second, 3 * time.Second}) || tl.CrossfadeOut != (Region{55 * time.Second, 60 * time.Second}) {
+		t.Errorf("On-air timeline = %+v", tl)
+	}
+
+	// Queued: crossfades estimated, capped at half the aired region.
+	if tl, err = p.Timeline("queued"); err != nil {
+		t.Fatal(err)
+	}
+	if !tl.Estimated || tl.CrossfadeIn != (Region{0, 3 * time.Second}) || tl.CrossfadeOut != (Region{3 * time.Second, 6 * time.Second}) {
+		t.Errorf("Queued timeline = %+v", tl)
+	}
+
+	p.recordHistory()
+	p.setTrack(TrackInfo{ID: "later"}, 10)
+	if tl, err = p.Timeline("now"); err != nil || tl.CrossfadeOut.Start != 55*time.Second {
+		t.Errorf("History timeline = %+v, %v", tl, err)
+	}
+	for _, id := range []string{"pending", "missing"} {
+		if _, err := p.Timeline(id); err == nil {
+			t.Errorf("Timeline(%q) succeeded", id)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package audio
+
+import "math"
+
+// Tempo and downbeat detection on decoded PCM.
+//
+// An onset-strength envelope (rectified log-energy flux, 10ms hops) is
+// autocorrelated to find the beat period, refined with a comb search over
+// the whole track to lock period and phase, and the downbeat is picked as
+// the beat phase (assuming 4/4) with the most low-frequency onset energy.
+
+const (
+	onsetHop          = SampleRate / 100 // 10ms envelope resolution (per channel)
+	minBPM            = 70.0
+	maxBPM            = 180.0
+	beatsPerBar       = 4
+	minBeatConfidence = 0.15
+	lowBandCutoff     = 150.0 // Hz, kick-drum band used for downbeat picking
+)
+
+// BeatGrid describes the detected tempo and bar positions of a track.
+// Positions are in samples per channel from the start of the track.
+type BeatGrid struct {
+	BPM        float64
+	Confidence float64 // 0-1, normalized autocorrelation at the beat period
+	Period     float64 // samples per beat
+	Downbeat   float64 // position of the first downbeat
+}
+
+// Confident reports whether the grid is reliable enough to align to.
+func (g BeatGrid) Confident() bool {
+	return g.BPM > 0 && g.Confidence >= minBeatConfidence
+}
+
+// bar returns the length of one bar in samples.
+func (g BeatGrid) bar() float64 {
+	return g.Period * beatsPerBar
+}
+
+// PrevDownbeat returns the last downbeat at or before pos, or -1 if none.
+func (g BeatGrid) PrevDownbeat(pos int) int {
+	if !g.Confident() || float64(pos) < g.Downbeat {
+		return -1
+	}
+	n := math.Floor((float64(pos) - g.Downbeat) / g.bar())
+	return int(math.Round(g.Downbeat + n*g.bar()))
+}
+
+// NextDownbeat returns the first downbeat at or after pos, or -1 if none.
+func (g BeatGrid) NextDownbeat(pos int) int {
+	if !g.Confident() {
+		return -1
+	}
+	if float64(pos) <= g.Downbeat {
+		return int(math.Round(g.Downbeat))
+	}
+	n := math.Ceil((float64(pos) - g.Downbeat) / g.bar())
+	return int(math.Round(g.Downbeat + n*g.bar()))
+}
+
+// Shift returns the grid as seen after dropping the first n samples.
+func (g BeatGrid) Shift(n int) BeatGrid {
+	if !g.Confident() {
+		return g
+	}
+	g.Downbeat -= float64(n)
+	for g.Downbeat < 0 {
+		g.Downbeat += g.bar()
+	}
+	return g
+}
+
+// BeatDetector accumulates interleaved stereo PCM into an onset envelope.
+// Feed it with Write in any chunk size, then call Grid.
+type BeatDetector struct {
+	lowState float64 // one-pole low-pass state for the kick band
+	lowCoef  float64
+
+	energy, lowEnergy float64
+	count             int
+	prevLog, prevLow  float64
+
+	onset []float64 // full-band onset strength per hop
+	low   []float64 // low-band onset strength per hop
+}
+
+// NewBeatDetector creates a detector for 48kHz stereo input.
+func NewBeatDetector() *BeatDetector {
+	return &BeatDetector{
+		lowCoef: 1 - math.Exp(-2*math.Pi*lowBandCutoff/SampleRate),
+	}
+}
+
+// Write feeds interleaved float samples (full scale ±1) into the detector.
+func (d *BeatDetector) Write(samples []float32) {
+	for i := 0; i+Channels <= len(samples); i += Channels {
+		x := (float64(samples[i]) + float64(samples[i+1])) / 2
+		d.lowState += d.lowCoef * (x - d.lowState)
+		d.energy += x * x
+		d.lowEnergy += d.lowState * d.lowState
+		d.count++
+		if d.count == onsetHop {
+			d.closeHop()
+		}
+	}
+}
+
+func (d *BeatDetector) closeHop() {
+	l := math.Log1p(1000 * d.energy)
+	lo := math.Log1p(1000 * d.lowEnergy)
+	d.onset = append(d.onset, math.Max(0, l-d.prevLog))
+	d.low = append(d.low, math.Max(0, lo-d.prevLow))
+	d.prevLog, d.prevLow = l, lo
+	d.energy, d.lowEnergy, d.count = 0, 0, 0
+}
+
+// Grid estimates the beat grid. Returns a zero-BPM grid when no tempo is found.
+func (d *BeatDetector) Grid() BeatGrid {
+	env := d.onset
+	minLag := int(math.Floor(6000 / maxBPM))
+	maxLag := int(math.Ceil(6000 / minBPM))
+	if len(env) < maxLag*8 {
+		return BeatGrid{}
+	}
+
+	// Mean-removed autocorrelation
+	var mean float64
+	for _, v := range env {
+		mean += v
+	}
+	mean /= float64(len(env))
+	centered := make([]float64, len(env))
+	for i, v := range env {
+		centered[i] = v - mean
+	}
+	ac := func(lag int) float64 {
+		var s float64
+		for i := lag; i < len(centered); i++ {
+			s += centered[i] * centered[i-lag]
+		}
+		return s
+	}
+	ac0 := ac(0)
+	if ac0 == 0 {
+		return BeatGrid{}
+	}
+
+	// Pick the lag with the strongest tempo-weighted correlation. The
+	// log-Gaussian prior around 120 BPM discourages half/double-time picks.
+	bestLag, bestScore, bestAC := 0, 0.0, 0.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		r := ac(lag) / ac0
+		octaves := math.Log2(6000 / float64(lag) / 120)
+		score := r * math.Exp(-0.5*octaves*octaves)
+		if score > bestScore {
+			bestLag, bestScore, bestAC = lag, score, r
+		}
+	}
+	if bestLag == 0 {
+		return BeatGrid{}
+	}
+
+	// Refine period and phase together: comb-sum the envelope along
+	// candidate grids at sub-hop resolution across the whole track.
+	period, phase, _ := combSearch(env, float64(bestLag)-1, float64(bestLag)+1, 0.02)
+
+	// Downbeat: the beat phase (of four) with the most kick-band onset energy.
+	var barScore [beatsPerBar]float64
+	for k := 0; ; k++ {
+		pos := phase + float64(k)*period
+		if pos >= float64(len(d.low)-1) {
+			break
+		}
+		barScore[k%beatsPerBar] += interp(d.low, pos)
+	}
+	best := 0
+	for i := range barScore {
+		if barScore[i] > barScore[best] {
+			best = i
+		}
+	}
+
+	return BeatGrid{
+		BPM:        6000 / period,
+		Confidence: math.Max(0, bestAC),
+		Period:     period * onsetHop,
+		Downbeat:   (phase + float64(best)*period) * onsetHop,
+	}
+}
+
+// combSearch finds the period (in hops) within [lo, hi] and phase that
+// maximize the mean envelope value sampled along the beat grid.
+func combSearch(env []float64, lo, hi, step float64) (period, phase, score float64) {
+	for p := lo; p <= hi; p += step {
+		for ph := 0.0; ph < p; ph += 0.25 {
+			var sum float64
+			n := 0
+			for pos := ph; pos < float64(len(env)-1); pos += p {
+				sum += interp(env, pos)
+				n++
+			}
+			if n > 0 && sum/float64(n) > score {
+				period, phase, score = p, ph, sum/float64(n)
+			}
+		}
+	}
+	return period, phase, score
+}
+
+// interp linearly interpolates v at fractional index pos.
+func interp(v []float64, pos float64) float64 {
+	i := int(pos)
+	frac := pos - float64(i)
+	return v[i]*(1-frac) + v[i+1]*frac
+}
+
+// DetectBeats returns the beat grid of interleaved stereo samples.
+func DetectBeats(samples []int16) BeatGrid {
+	d := NewBeatDetector()
+	d.Write(Int16ToFloat(samples))
+	return d.Grid()
+}
@@ -0,0 +1,105 @@
+package audio
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source that paces the pipeline. The real clock is
+// wall time; tests inject a FakeClock so playback runs instantly.
+type Clock interface {
+	Now() time.Time
+	// After returns a channel that receives once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// FakeClock is a Clock whose time only moves when waited on or advanced.
+// After advances the clock by d and fires immediately, so a pipeline
+// driven by it plays as fast as it can while seeing real-time deadlines.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock creates a fake clock starting at start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.Advance(d)
+	return ch
+}
+
+// Advance moves the clock forward by d (e.g. to simulate a stall) and
+// returns the new time.
+func (c *FakeClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// maxCatchUp is how far behind the clock may fall before the schedule is
+// reset instead of bursting frames to catch up.
+const maxCatchUp = time.Second
+
+// ClockStats reports how closely frames kept to their deadlines.
+type ClockStats struct {
+	Frames   uint64        // frames sent
+	Late     uint64        // frames sent more than one frame period after their deadline
+	Resyncs  uint64        // stalls longer than maxCatchUp that reset the schedule
+	LastLate time.Duration // lateness of the most recent frame
+	MaxLate  time.Duration // worst lateness seen
+}
+
+// frameClock schedules frames against absolute deadlines (start + n*20ms)
+// rather than ticks, so scheduling jitter never accumulates into drift.
+// After a stall it sends frames back to back until it is on schedule
+// again.
+type frameClock struct {
+	clock    Clock
+	deadline time.Time // when the next frame is due
+}
+
+// wait returns a channel that fires when the next frame is due, or nil if
+// it is already due.
+func (c *frameClock) wait() <-chan time.Time {
+	if d := c.deadline.Sub(c.clock.Now()); d > 0 {
+		return c.clock.After(d)
+	}
+	return nil
+}
+
+// sent records that the next frame went out and advances the deadline.
+// Returns how late the frame was and whether the schedule was reset.
+func (c *frameClock) sent() (late time.Duration, resync bool) {
+	now := c.clock.Now()
+	late = max(0, now.Sub(c.deadline))
+	c.deadline = c.deadline.Add(FrameDuration)
+	if late > maxCatchUp {
+		c.deadline = now.Add(FrameDuration)
+		resync = true
+	}
+	return late, resync
+}
+
+// idle moves the schedule up to now after a gap in playback (nothing to
+// play), so the next track doesn't burst to make up for it.
+func (c *frameClock) idle() {
+	if now := c.clock.Now(); now.After(c.deadline) {
+		c.deadline = now
+	}
+}
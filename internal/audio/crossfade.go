@@ -15,6 +15,7 @@ func Smoothstep(t float64) float64 {
 // CrossfadeFrames blends an outgoing frame with an incoming frame at the given
 // progress (0.0 = all outgoing, 1.0 = all incoming). Uses smoothstep curve.
 // Both frames must have the same length. Returns the blended frame.
+// The pipeline uses the configurable Transition instead; see transition.go.
 func CrossfadeFrames(outgoing, incoming []int16, progress float64) []int16 {
 	gain := Smoothstep(progress)
 	result := make([]int16, len(outgoing))
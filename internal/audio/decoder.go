@@ -1,15 +1,20 @@
 package audio
 
 import (
+	"bufio"
+	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
 	"os/exec"
+	"time"
 )
 
-// DecodeFile runs FFmpeg to decode an audio file to raw PCM int16 samples.
-// Returns interleaved stereo samples at 48kHz.
-func DecodeFile(path string) ([]int16, error) {
-	cmd := exec.Command("ffmpeg",
+// ffmpegDecodeArgs returns FFmpeg arguments that decode path to 48kHz
+// stereo s16le on stdout.
+func ffmpegDecodeArgs(path string) []string {
+	return []string{
 		"-i", path,
 		"-f", "s16le",
 		"-acodec", "pcm_s16le",
@@ -17,7 +22,14 @@ func DecodeFile(path string) ([]int16, error) {
 		"-ac", "2",
 		"-loglevel", "error",
 		"pipe:1",
-	)
+	}
+}
+
+// DecodeFile runs FFmpeg to decode an audio file to raw PCM int16 samples.
+// Returns interleaved stereo samples at 48kHz. The whole file is held in
+// memory; the pipeline streams tracks with OpenStream instead.
+func DecodeFile(path string) ([]int16, error) {
+	cmd := exec.Command("ffmpeg", ffmpegDecodeArgs(path)...)
 
 	out, err := cmd.Output()
 	if err != nil {
@@ -45,3 +57,210 @@ func SamplesToBytes(samples []int16) []byte {
 	}
 	return buf
 }
+
+// Int16ToFloat converts int16 samples to float32 at full scale ±1.
+func Int16ToFloat(samples []int16) []float32 {
+	out := make([]float32, len(samples))
+	for i, s := range samples {
+		out[i] = float32(s) / 32768
+	}
+	return out
+}
+
+// FloatToInt16 converts float32 samples back to int16, rounding and
+// clipping anything outside full scale. The pipeline limits its output
+// below full scale, so this is only a safety net at the encoder boundary.
+func FloatToInt16(samples []float32) []int16 {
+	out := make([]int16, len(samples))
+	for i, s := range samples {
+		v := math.Round(float64(s) * 32768)
+		if v > 32767 {
+			v = 32767
+		} else if v < -32768 {
+			v = -32768
+		}
+		out[i] = int16(v)
+	}
+	return out
+}
+
+// frameSource yields a track's PCM as consecutive 20ms frames.
+type frameSource interface {
+	// ReadFrame returns the next FrameSamples interleaved samples, or false
+	// at the end of the track. A trailing partial frame is dropped.
+	ReadFrame() ([]float32, bool)
+	// Skip discards n samples per channel.
+	Skip(n int)
+	Close() error
+}
+
+// Stream decodes a file incrementally through FFmpeg. Only a lookahead
+// window of frames is held in memory; FFmpeg itself blocks on the pipe
+// until the pipeline reads further, so a queued track costs its window
+// plus the OS pipe buffer rather than the whole decoded file.
+type Stream struct {
+	cmd    *exec.Cmd
+	out    io.ReadCloser
+	r      *bufio.Reader
+	gain   float64   // linear gain applied to every sample
+	window []float32 // decoded samples not yet handed out
+	eof    bool
+}
+
+// OpenStream starts FFmpeg decoding path, applying gainDB to every sample.
+// Samples are converted to float32 so the gain never clips.
+// The process is killed when ctx is cancelled or Close is called.
+func OpenStream(ctx context.Context, path string, gainDB float64) (*Stream, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegDecodeArgs(path)...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg stream %s: %w", path, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ffmpeg stream %s: %w", path, err)
+	}
+	return &Stream{
+		cmd:  cmd,
+		out:  out,
+		r:    bufio.NewReaderSize(out, FrameBytes*4),
+		gain: math.Pow(10, gainDB/20),
+	}, nil
+}
+
+// Prefetch decodes ahead until at least frames frames are buffered in the
+// lookahead window (or the track ends).
+func (s *Stream) Prefetch(frames int) {
+	for len(s.window) < frames*FrameSamples && !s.eof {
+		s.decodeFrame()
+	}
+}
+
+// Buffered returns the number of whole frames in the lookahead window.
+func (s *Stream) Buffered() int {
+	return len(s.window) / FrameSamples
+}
+
+// decodeFrame reads one frame from FFmpeg into the window.
+func (s *Stream) decodeFrame() {
+	buf := make([]byte, FrameBytes)
+	n, err := io.ReadFull(s.r, buf)
+	n -= n % (2 * Channels)
+	for i := 0; i < n; i += 2 {
+		v := float64(int16(binary.LittleEndian.Uint16(buf[i:]))) / 32768 * s.gain
+		s.window = append(s.window, float32(v))
+	}
+	if err != nil {
+		s.eof = true
+	}
+}
+
+// ReadFrame returns the next 20ms frame.
+func (s *Stream) ReadFrame() ([]float32, bool) {
+	if len(s.window) < FrameSamples {
+		s.Prefetch(1)
+	}
+	if len(s.window) < FrameSamples {
+		return nil, false
+	}
+	frame := make([]float32, FrameSamples)
+	copy(frame, s.window)
+	s.window = s.window[FrameSamples:]
+	return frame, true
+}
+
+// Skip discards n samples per channel, for sample-accurate alignment.
+func (s *Stream) Skip(n int) {
+	need := n * Channels
+	for len(s.window) < need && !s.eof {
+		s.decodeFrame()
+	}
+	if need > len(s.window) {
+		need = len(s.window)
+	}
+	s.window = s.window[need:]
+}
+
+// Close stops FFmpeg and releases the window.
+func (s *Stream) Close() error {
+	s.window = nil
+	s.out.Close()
+	if s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+	}
+	s.cmd.Wait()
+	return nil
+}
+
+// ExportTrimmed re-encodes the region [start, end) of path to w in the
+// given container format (flac, mp3, wav).
+func ExportTrimmed(ctx context.Context, w io.Writer, path, format string, start, end time.Duration) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-ss", fmt.Sprintf("%.3f", start.Seconds()),
+		"-to", fmt.Sprintf("%.3f", end.Seconds()),
+		"-i", path,
+		"-f", format,
+		"-loglevel", "error",
+		"pipe:1",
+	)
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg export %s: %w", path, err)
+	}
+	return nil
+}
+
+// Analysis holds whole-track measurements taken before playback.
+type Analysis struct {
+	Samples  int     // length in samples per channel (whole frames only)
+	Loudness float64 // integrated loudness (LUFS)
+	TruePeak float64 // true peak (dBTP)
+	Beats    BeatGrid
+	Key      Key     // zero if no key was confident
+	KeyScore float64 // key profile correlation
+	Centroid float64 // average spectral centroid (Hz)
+
+	levels []float32 // RMS per 10ms window, for silence trimming and energy
+	peaks  []float32 // peak per 10ms window, for the waveform
+}
+
+// AnalyzeFile streams a file through the loudness meter, beat detector and
+// spectrum analyzer without keeping its PCM in memory.
+func AnalyzeFile(ctx context.Context, path string) (Analysis, error) {
+	s, err := OpenStream(ctx, path, 0)
+	if err != nil {
+		return Analysis{}, err
+	}
+	defer s.Close()
+	a := analyze(s)
+	if a.Samples == 0 {
+		return a, fmt.Errorf("ffmpeg decode %s: no audio", path)
+	}
+	return a, nil
+}
+
+// analyze reads a source to the end and measures it.
+func analyze(src frameSource) Analysis {
+	meter := NewLoudnessMeter()
+	beats := NewBeatDetector()
+	levels := &levelMeter{}
+	spectrum := newSpectrumAnalyzer()
+	var a Analysis
+	for {
+		frame, ok := src.ReadFrame()
+		if !ok {
+			break
+		}
+		meter.Write(frame)
+		beats.Write(frame)
+		levels.Write(frame)
+		spectrum.Write(frame)
+		a.Samples += FrameSize
+	}
+	a.Loudness = meter.Integrated()
+	a.TruePeak = meter.TruePeak()
+	a.Beats = beats.Grid()
+	a.Key, a.KeyScore = spectrum.Key()
+	a.Centroid = spectrum.Centroid()
+	a.levels, a.peaks = levels.levels, levels.peaks
+	return a
+}
@@ -0,0 +1,304 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Effect processes the pipeline output one 20ms frame at a time.
+// Implementations keep their own filter state and read their parameters
+// on every frame, so SetParam takes effect on the next frame.
+type Effect interface {
+	Name() string
+	// Process modifies an interleaved stereo frame in place.
+	Process(frame []float32, info FrameInfo)
+	Params() map[string]float64
+	SetParam(name string, value float64) error
+}
+
+// FrameInfo tells effects where the pipeline is in playback.
+type FrameInfo struct {
+	// GenreChange is 0 outside a transition between two genres and rises
+	// to 1 at the midpoint of one.
+	GenreChange float64
+}
+
+// EffectState is a snapshot of one effect in the chain.
+type EffectState struct {
+	Name   string             `json:"name"`
+	Bypass bool               `json:"bypass"`
+	Params map[string]float64 `json:"params"`
+}
+
+// EffectChain runs effects in order. It is safe to adjust from other
+// goroutines while the pipeline is processing.
+type EffectChain struct {
+	mu      sync.Mutex
+	effects []Effect
+	bypass  []bool
+}
+
+// NewEffectChain creates the station chain: master EQ, stereo width,
+// genre-transition muffle and tape saturation (bypassed by default).
+func NewEffectChain() *EffectChain {
+	c := &EffectChain{}
+	c.Add(NewEQ(), false)
+	c.Add(NewStereoWidth(), false)
+	c.Add(NewMuffle(), false)
+	c.Add(NewTapeSaturation(), true)
+	return c
+}
+
+// Add appends an effect to the end of the chain.
+func (c *EffectChain) Add(e Effect, bypass bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.effects = append(c.effects, e)
+	c.bypass = append(c.bypass, bypass)
+}
+
+// Process runs every effect that isn't bypassed over the frame in place.
+func (c *EffectChain) Process(frame []float32, info FrameInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, e := range c.effects {
+		if !c.bypass[i] {
+			e.Process(frame, info)
+		}
+	}
+}
+
+// SetBypass enables or bypasses the named effect.
+func (c *EffectChain) SetBypass(name string, bypass bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.index(name)
+	if i < 0 {
+		return fmt.Errorf("unknown effect %q", name)
+	}
+	c.bypass[i] = bypass
+	return nil
+}
+
+// SetParam sets a parameter on the named effect.
+func (c *EffectChain) SetParam(name, param string, value float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.index(name)
+	if i < 0 {
+		return fmt.Errorf("unknown effect %q", name)
+	}
+	if err := c.effects[i].SetParam(param, value); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// States returns a snapshot of the chain in processing order.
+func (c *EffectChain) States() []EffectState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	states := make([]EffectState, len(c.effects))
+	for i, e := range c.effects {
+		states[i] = EffectState{Name: e.Name(), Bypass: c.bypass[i], Params: e.Params()}
+	}
+	return states
+}
+
+func (c *EffectChain) index(name string) int {
+	for i, e := range c.effects {
+		if e.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// param is a bounded effect parameter.
+type param struct {
+	value, min, max float64
+}
+
+// params implements Params and SetParam for effects.
+type params map[string]*param
+
+func (ps params) Params() map[string]float64 {
+	out := make(map[string]float64, len(ps))
+	for name, p := range ps {
+		out[name] = p.value
+	}
+	return out
+}
+
+func (ps params) SetParam(name string, value float64) error {
+	p, ok := ps[name]
+	if !ok {
+		names := make([]string, 0, len(ps))
+		for n := range ps {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown parameter %q (have %v)", name, names)
+	}
+	if math.IsNaN(value) || value < p.min || value > p.max {
+		return fmt.Errorf("%s must be %g to %g", name, p.min, p.max)
+	}
+	p.value = value
+	return nil
+}
+
+// EQ is a three-band master EQ: low shelf at 120Hz, peak at 1kHz and high
+// shelf at 8kHz, each ±12dB. Flat by default.
+type EQ struct {
+	params
+	low, mid, high [Channels]biquad
+}
+
+// NewEQ creates a flat EQ.
+func NewEQ() *EQ {
+	return &EQ{params: params{
+		"low":  {0, -12, 12},
+		"mid":  {0, -12, 12},
+		"high": {0, -12, 12},
+	}}
+}
+
+func (e *EQ) Name() string { return "eq" }
+
+func (e *EQ) Process(frame []float32, _ FrameInfo) {
+	low, mid, high := e.params["low"].value, e.params["mid"].value, e.params["high"].value
+	if low == 0 && mid == 0 && high == 0 {
+		return
+	}
+	for ch := 0; ch < Channels; ch++ {
+		e.low[ch].setLowShelf(120, low)
+		e.mid[ch].setPeaking(1000, 0.7, mid)
+		e.high[ch].setHighShelf(8000, high)
+	}
+	for i, x := range frame {
+		ch := i % Channels
+		frame[i] = float32(e.high[ch].process(e.mid[ch].process(e.low[ch].process(float64(x)))))
+	}
+}
+
+// StereoWidth scales the side (L-R) signal: 0 is mono, 1 unchanged, 2 wide.
+type StereoWidth struct {
+	params
+}
+
+// NewStereoWidth creates a width control at 1 (unchanged).
+func NewStereoWidth() *StereoWidth {
+	return &StereoWidth{params: params{"width": {1, 0, 2}}}
+}
+
+func (w *StereoWidth) Name() string { return "width" }
+
+func (w *StereoWidth) Process(frame []float32, _ FrameInfo) {
+	width := float32(w.params["width"].value)
+	if width == 1 {
+		return
+	}
+	for i := 0; i+1 < len(frame); i += Channels {
+		mid := (frame[i] + frame[i+1]) / 2
+		side := (frame[i] - frame[i+1]) / 2 * width
+		frame[i], frame[i+1] = mid+side, mid-side
+	}
+}
+
+// Muffle is the "muffled radio" low-pass for genre changes: as a
+// transition between genres approaches its midpoint, the cutoff sweeps
+// down from 20kHz to the cutoff parameter, then opens again.
+type Muffle struct {
+	params
+	filters [Channels]biquad
+}
+
+// NewMuffle creates a muffle that closes to 1kHz.
+func NewMuffle() *Muffle {
+	return &Muffle{params: params{"cutoff": {1000, 200, 20000}}}
+}
+
+func (m *Muffle) Name() string { return "muffle" }
+
+func (m *Muffle) Process(frame []float32, info FrameInfo) {
+	if info.GenreChange <= 0 {
+		m.filters = [Channels]biquad{} // start clean next transition
+		return
+	}
+	cutoff := 20000 * math.Pow(m.params["cutoff"].value/20000, math.Min(1, info.GenreChange))
+	for ch := range m.filters {
+		m.filters[ch].setLowPass(cutoff, 0.707)
+	}
+	for i, x := range frame {
+		frame[i] = float32(m.filters[i%Channels].process(float64(x)))
+	}
+}
+
+// TapeSaturation soft-clips with tanh for analogue-style warmth. drive
+// (dB) pushes the signal into the curve; output is scaled back so quiet
+// passages keep their level. mix blends with the dry signal.
+type TapeSaturation struct {
+	params
+}
+
+// NewTapeSaturation creates a saturator with 6dB drive, fully wet.
+func NewTapeSaturation() *TapeSaturation {
+	return &TapeSaturation{params: params{
+		"drive": {6, 0, 24},
+		"mix":   {1, 0, 1},
+	}}
+}
+
+func (t *TapeSaturation) Name() string { return "tape" }
+
+func (t *TapeSaturation) Process(frame []float32, _ FrameInfo) {
+	g := math.Pow(10, t.params["drive"].value/20)
+	mix := t.params["mix"].value
+	for i, x := range frame {
+		wet := math.Tanh(float64(x)*g) / g
+		frame[i] = float32(float64(x)*(1-mix) + wet*mix)
+	}
+}
+
+// RBJ cookbook shelving and peaking filters. Like setLowPass, these keep
+// the filter state so parameters can change without clicks.
+
+func (f *biquad) setLowShelf(freq, gainDB float64) {
+	a := math.Pow(10, gainDB/40)
+	w := 2 * math.Pi * freq / SampleRate
+	cosw, alpha := math.Cos(w), math.Sin(w)/2*math.Sqrt2
+	sa := 2 * math.Sqrt(a) * alpha
+	a0 := (a + 1) + (a-1)*cosw + sa
+	f.b0 = a * ((a + 1) - (a-1)*cosw + sa) / a0
+	f.b1 = 2 * a * ((a - 1) - (a+1)*cosw) / a0
+	f.b2 = a * ((a + 1) - (a-1)*cosw - sa) / a0
+	f.a1 = -2 * ((a - 1) + (a+1)*cosw) / a0
+	f.a2 = ((a + 1) + (a-1)*cosw - sa) / a0
+}
+
+func (f *biquad) setHighShelf(freq, gainDB float64) {
+	a := math.Pow(10, gainDB/40)
+	w := 2 * math.Pi * freq / SampleRate
+	cosw, alpha := math.Cos(w), math.Sin(w)/2*math.Sqrt2
+	sa := 2 * math.Sqrt(a) * alpha
+	a0 := (a + 1) - (a-1)*cosw + sa
+	f.b0 = a * ((a + 1) + (a-1)*cosw + sa) / a0
+	f.b1 = -2 * a * ((a - 1) + (a+1)*cosw) / a0
+	f.b2 = a * ((a + 1) + (a-1)*cosw - sa) / a0
+	f.a1 = 2 * ((a - 1) - (a+1)*cosw) / a0
+	f.a2 = ((a + 1) - (a-1)*cosw - sa) / a0
+}
+
+func (f *biquad) setPeaking(freq, q, gainDB float64) {
+	a := math.Pow(10, gainDB/40)
+	w := 2 * math.Pi * freq / SampleRate
+	cosw, alpha := math.Cos(w), math.Sin(w)/(2*q)
+	a0 := 1 + alpha/a
+	f.b0 = (1 + alpha*a) / a0
+	f.b1 = -2 * cosw / a0
+	f.b2 = (1 - alpha*a) / a0
+	f.a1 = -2 * cosw / a0
+	f.a2 = (1 - alpha/a) / a0
+}
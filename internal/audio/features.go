@@ -0,0 +1,235 @@
+package audio
+
+import "math"
+
+// Spectral features from the analysis pass: musical key (chroma +
+// Krumhansl-Schmuckler profiles) and brightness (spectral centroid).
+// The mono downmix is cut into non-overlapping 4096-sample blocks
+// (~85ms, 11.7Hz bins), which is plenty for whole-track statistics.
+
+const (
+	fftSize          = 4096
+	chromaMinHz      = 55   // A1; lower bins are too coarse for pitch
+	chromaMaxHz      = 2000 // above this harmonics blur the chroma
+	keyMinConfidence = 0.5  // minimum profile correlation to report a key
+	energyHop        = 100  // level windows per energy curve point (1s)
+	harmonicDiscount = 0.5  // fraction of a fundamental removed from its 3rd harmonic
+)
+
+// Mode is major or minor.
+type Mode string
+
+const (
+	Major Mode = "major"
+	Minor Mode = "minor"
+)
+
+// Key is a musical key. The zero value means the key is unknown.
+type Key struct {
+	Tonic int  // pitch class, 0 = C ... 11 = B
+	Mode  Mode // "" if unknown
+}
+
+var pitchNames = [12]string{"C", "C#", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+
+// Known reports whether the key was detected.
+func (k Key) Known() bool { return k.Mode != "" }
+
+// String formats the key as e.g. "A minor", or "" if unknown.
+func (k Key) String() string {
+	if !k.Known() {
+		return ""
+	}
+	return pitchNames[k.Tonic] + " " + string(k.Mode)
+}
+
+// Krumhansl-Kessler key profiles, indexed from the tonic.
+var (
+	majorProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+	minorProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+)
+
+// hann is the analysis window for fftSize blocks.
+var hann = func() []float64 {
+	w := make([]float64, fftSize)
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/fftSize)
+	}
+	return w
+}()
+
+// spectrumAnalyzer accumulates chroma and spectral centroid.
+type spectrumAnalyzer struct {
+	block    []float64
+	re, im   []float64
+	chroma   [12]float64
+	centroid float64 // magnitude-weighted sum of block centroids
+	weight   float64
+}
+
+func newSpectrumAnalyzer() *spectrumAnalyzer {
+	return &spectrumAnalyzer{
+		block: make([]float64, 0, fftSize),
+		re:    make([]float64, fftSize),
+		im:    make([]float64, fftSize),
+	}
+}
+
+// Write feeds interleaved float samples into the analyzer.
+func (s *spectrumAnalyzer) Write(samples []float32) {
+	for i := 0; i+Channels <= len(samples); i += Channels {
+		s.block = append(s.block, (float64(samples[i])+float64(samples[i+1]))/2)
+		if len(s.block) == fftSize {
+			s.analyzeBlock()
+			s.block = s.block[:0]
+		}
+	}
+}
+
+func (s *spectrumAnalyzer) analyzeBlock() {
+	for i, x := range s.block {
+		s.re[i], s.im[i] = x*hann[i], 0
+	}
+	fft(s.re, s.im)
+
+	mags := s.re[:fftSize/2]
+	for k := range mags {
+		mags[k] = math.Hypot(s.re[k], s.im[k])
+	}
+
+	var magSum, freqSum float64
+	for k := 1; k < len(mags); k++ {
+		f := float64(k) * SampleRate / fftSize
+		magSum += mags[k]
+		freqSum += f * mags[k]
+		if f >= chromaMinHz && f <= chromaMaxHz {
+			// The 3rd harmonic of every note lands on its fifth and pulls
+			// the key towards the dominant; discount what a fundamental
+			// an octave and a fifth below would put here.
+			m := mags[k] - mags[(k+1)/3]*harmonicDiscount
+			if m > 0 {
+				s.chroma[pitchClass(f)] += m
+			}
+		}
+	}
+	if magSum > 0 {
+		// Weighting by magnitude keeps quiet blocks (fades, noise floor)
+		// from dragging the average around.
+		s.centroid += freqSum
+		s.weight += magSum
+	}
+}
+
+// Centroid returns the average spectral centroid in Hz (0 for silence).
+func (s *spectrumAnalyzer) Centroid() float64 {
+	if s.weight == 0 {
+		return 0
+	}
+	return s.centroid / s.weight
+}
+
+// Key returns the best-matching key and its profile correlation.
+func (s *spectrumAnalyzer) Key() (Key, float64) {
+	return detectKey(s.chroma)
+}
+
+// pitchClass maps a frequency to its nearest pitch class (0 = C).
+func pitchClass(f float64) int {
+	midi := int(math.Round(69 + 12*math.Log2(f/440)))
+	return ((midi % 12) + 12) % 12
+}
+
+// detectKey correlates a chroma vector with the major and minor profiles
+// in all 12 transpositions.
+func detectKey(chroma [12]float64) (Key, float64) {
+	best, bestCorr := Key{}, -1.0
+	for tonic := 0; tonic < 12; tonic++ {
+		var rotated [12]float64
+		for i := range rotated {
+			rotated[i] = chroma[(tonic+i)%12]
+		}
+		for _, m := range []struct {
+			mode    Mode
+			profile [12]float64
+		}{{Major, majorProfile}, {Minor, minorProfile}} {
+			if c := correlate(rotated, m.profile); c > bestCorr {
+				best, bestCorr = Key{Tonic: tonic, Mode: m.mode}, c
+			}
+		}
+	}
+	if bestCorr < keyMinConfidence {
+		return Key{}, math.Max(bestCorr, 0)
+	}
+	return best, bestCorr
+}
+
+// correlate returns the Pearson correlation of two vectors (0 if either
+// is flat).
+func correlate(a, b [12]float64) float64 {
+	var ma, mb float64
+	for i := range a {
+		ma += a[i]
+		mb += b[i]
+	}
+	ma /= 12
+	mb /= 12
+	var cov, va, vb float64
+	for i := range a {
+		cov += (a[i] - ma) * (b[i] - mb)
+		va += (a[i] - ma) * (a[i] - ma)
+		vb += (b[i] - mb) * (b[i] - mb)
+	}
+	if va == 0 || vb == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(va*vb)
+}
+
+// energyCurve condenses 10ms RMS levels into one point per second,
+// scaled by gainDB so curves are comparable across tracks.
+func energyCurve(levels []float32, gainDB float64) []float32 {
+	g := math.Pow(10, gainDB/20)
+	curve := make([]float32, 0, len(levels)/energyHop+1)
+	for i := 0; i < len(levels); i += energyHop {
+		chunk := levels[i:min(i+energyHop, len(levels))]
+		var sum float64
+		for _, l := range chunk {
+			sum += float64(l) * float64(l)
+		}
+		curve = append(curve, float32(math.Sqrt(sum/float64(len(chunk)))*g))
+	}
+	return curve
+}
+
+// fft computes an in-place iterative radix-2 FFT. len(re) must be a
+// power of two.
+func fft(re, im []float64) {
+	n := len(re)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			re[i], re[j] = re[j], re[i]
+			im[i], im[j] = im[j], im[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		half := size / 2
+		wr, wi := math.Cos(-2*math.Pi/float64(size)), math.Sin(-2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			cr, ci := 1.0, 0.0
+			for k := 0; k < half; k++ {
+				a, b := start+k, start+k+half
+				tr := re[b]*cr - im[b]*ci
+				ti := re[b]*ci + im[b]*cr
+				re[b], im[b] = re[a]-tr, im[a]-ti
+				re[a] += tr
+				im[a] += ti
+				cr, ci = cr*wr-ci*wi, cr*wi+ci*wr
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package audio
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Play history: a ring of recently aired tracks. Metadata is kept for the
+// last historySize tracks; only the newest historyReplayable keep their
+// file path, since older generations may be cleaned off the shared volume.
+
+const (
+	historySize       = 50
+	historyReplayable = 10
+)
+
+// HistoryEntry is one aired track.
+type HistoryEntry struct {
+	Track      TrackInfo     // Path is cleared once the entry is no longer replayable
+	PlayedAt   time.Time     // when the track went on air
+	Aired      time.Duration // how much of it played (less than its duration if skipped)
+	Replayable bool
+}
+
+// History returns the aired tracks, newest first.
+func (p *Pipeline) History() []HistoryEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	entries := make([]HistoryEntry, len(p.history))
+	for i, e := range p.history {
+		entries[len(p.history)-1-i] = e
+	}
+	return entries
+}
+
+// recordHistory adds the current track to the history once it finishes.
+func (p *Pipeline) recordHistory() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.currentTrack.ID == "" || p.currentTrack.Interstitial {
+		return
+	}
+	p.history = append(p.history, HistoryEntry{
+		Track:      p.currentTrack,
+		PlayedAt:   p.trackStarted,
+		Aired:      p.trackPosition + FrameDuration,
+		Replayable: p.currentTrack.Path != "",
+	})
+	if n := len(p.history) - historySize; n > 0 {
+		p.history = append(p.history[:0], p.history[n:]...)
+	}
+	if i := len(p.history) - 1 - historyReplayable; i >= 0 {
+		p.history[i].Track.Path = ""
+		p.history[i].Replayable = false
+	}
+}
+
+// lastMusic returns the last generated track played, skipping jingles.
+func (p *Pipeline) lastMusic() TrackInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.currentTrack.Interstitial {
+		return p.currentTrack
+	}
+	if n := len(p.history); n > 0 {
+		return p.history[n-1].Track
+	}
+	return TrackInfo{}
+}
+
+// Replay puts a track from the history back at the front of the queue,
+// pinned so that it plays next even while it is being decoded.
+func (p *Pipeline) Replay(id string) error {
+	p.mu.RLock()
+	var track TrackInfo
+	for _, e := range p.history {
+		if e.Track.ID == id && e.Replayable {
+			track = e.Track
+		}
+	}
+	p.mu.RUnlock()
+	if track.ID == "" {
+		return fmt.Errorf("track %q not in replayable history", id)
+	}
+	p.queue.addFront(TrackInfo{ID: track.ID, Genre: track.Genre, Path: track.Path, Name: track.Name, Caption: track.Caption}, true)
+	log.Printf("Replaying %s", id)
+	return nil
+}
+
+// Previous replays the last aired track now, skipping the current one.
+func (p *Pipeline) Previous() error {
+	p.mu.RLock()
+	var id string
+	if n := len(p.history); n > 0 {
+		id = p.history[n-1].Track.ID
+	}
+	p.mu.RUnlock()
+	if id == "" {
+		return fmt.Errorf("no previous track")
+	}
+	if err := p.Replay(id); err != nil {
+		return err
+	}
+	p.Skip()
+	return nil
+}
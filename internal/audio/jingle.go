@@ -0,0 +1,261 @@
+package audio
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Jingles: short station IDs from a local directory, inserted between
+// generated tracks on a per-genre schedule. They are interstitials: they
+// go through the normal decode path (so they're loudness-normalized) but
+// are left out of the history, ratings and the Auto-DJ's buffer count.
+
+// JingleMode selects how a jingle is played.
+type JingleMode string
+
+const (
+	JingleBetween JingleMode = "between" // its own queue entry, crossfaded like a track
+	JingleOverlay JingleMode = "overlay" // mixed over the tail of a crossfade, music ducked
+)
+
+// JingleModes lists the supported jingle modes.
+var JingleModes = []JingleMode{JingleBetween, JingleOverlay}
+
+const (
+	jingleDuckDB   = 8.0                    // music level under an overlay jingle
+	jingleDuckRamp = 300 * time.Millisecond // duck and release time
+)
+
+var jingleDuck = math.Pow(10, -jingleDuckDB/20)
+
+// JingleRule schedules jingles for a genre: every EveryTracks generated
+// tracks, or once Every has passed, whichever comes first. A zero rule
+// never plays jingles.
+type JingleRule struct {
+	EveryTracks int
+	Every       time.Duration
+	Mode        JingleMode
+}
+
+// String formats the rule as "every[:mode]", the form ParseJingleRule
+// accepts: "4" for every 4 tracks, "15m0s:overlay" for every 15 minutes.
+func (r JingleRule) String() string {
+	every := strconv.Itoa(r.EveryTracks)
+	if r.Every > 0 {
+		every = r.Every.String()
+	}
+	return every + ":" + string(r.Mode)
+}
+
+// ParseJingleRule parses "every" or "every:mode", where every is a track
+// count or a duration. A missing mode defaults to between.
+func ParseJingleRule(v string) (JingleRule, error) {
+	everyStr, modeStr, _ := strings.Cut(strings.TrimSpace(v), ":")
+	rule := JingleRule{Mode: JingleBetween}
+	if modeStr != "" {
+		rule.Mode = JingleMode(modeStr)
+	}
+	if rule.Mode != JingleBetween && rule.Mode != JingleOverlay {
+		return JingleRule{}, fmt.Errorf("unknown jingle mode %q", modeStr)
+	}
+	if n, err := strconv.Atoi(everyStr); err == nil && n >= 0 {
+		rule.EveryTracks = n
+	} else if d, err := time.ParseDuration(everyStr); err == nil && d >= 0 {
+		rule.Every = d
+	} else {
+		return JingleRule{}, fmt.Errorf("jingle interval %q: want a track count or a duration", everyStr)
+	}
+	return rule, nil
+}
+
+// ParseJingleRules parses per-genre rules of the form
+// "genre=every[:mode],...". The genre "*" matches any genre without a
+// rule of its own.
+func ParseJingleRules(v string) (map[string]JingleRule, error) {
+	rules := make(map[string]JingleRule)
+	for _, r := range strings.Split(v, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		genre, ruleStr, ok := strings.Cut(r, "=")
+		if !ok {
+			return nil, fmt.Errorf("jingle rule %q: missing '='", r)
+		}
+		rule, err := ParseJingleRule(ruleStr)
+		if err != nil {
+			return nil, fmt.Errorf("jingle rule %q: %w", r, err)
+		}
+		rules[strings.TrimSpace(genre)] = rule
+	}
+	return rules, nil
+}
+
+// jingleScheduler decides when a jingle is due and which one to play.
+type jingleScheduler struct {
+	mu     sync.Mutex
+	dir    string
+	rules  map[string]JingleRule
+	tracks int       // generated tracks started since the last jingle
+	last   time.Time // when the last jingle was scheduled (or the first track)
+	played string    // last jingle file, not repeated back to back
+	seq    int
+}
+
+// due counts a generated track starting in genre at now and reports the
+// rule to play a jingle by, if one is due.
+func (j *jingleScheduler) due(genre string, now time.Time) (JingleRule, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rule, ok := j.rules[genre]
+	if !ok {
+		rule, ok = j.rules["*"]
+	}
+	if j.last.IsZero() {
+		j.last = now
+	}
+	j.tracks++
+	if !ok || j.dir == "" {
+		return JingleRule{}, false
+	}
+	if (rule.EveryTracks > 0 && j.tracks >= rule.EveryTracks) || (rule.Every > 0 && now.Sub(j.last) >= rule.Every) {
+		j.tracks, j.last = 0, now
+		return rule, true
+	}
+	return JingleRule{}, false
+}
+
+// pick chooses a jingle at random, avoiding the last one played.
+func (j *jingleScheduler) pick() (TrackInfo, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	files, err := audioFiles(j.dir)
+	if err != nil {
+		return TrackInfo{}, err
+	}
+	if len(files) > 1 {
+		for i, f := range files {
+			if f == j.played {
+				files = append(files[:i], files[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(files) == 0 {
+		return TrackInfo{}, fmt.Errorf("no jingles in %s", j.dir)
+	}
+	name := files[rand.IntN(len(files))]
+	j.played = name
+	j.seq++
+	return TrackInfo{
+		ID:           fmt.Sprintf("jingle-%d", j.seq),
+		Path:         filepath.Join(j.dir, name),
+		Name:         strings.TrimSuffix(name, filepath.Ext(name)),
+		Interstitial: true,
+	}, nil
+}
+
+// SetJingleDir sets the directory station IDs and jingles are picked from.
+// An empty dir disables jingles.
+func (p *Pipeline) SetJingleDir(dir string) {
+	p.jingles.mu.Lock()
+	p.jingles.dir = dir
+	p.jingles.mu.Unlock()
+	log.Printf("Jingle dir: %q", dir)
+}
+
+// SetJingleRules replaces the per-genre jingle schedule. Keys are genres
+// or "*" for the default.
+func (p *Pipeline) SetJingleRules(rules map[string]JingleRule) {
+	copied := make(map[string]JingleRule, len(rules))
+	for k, v := range rules {
+		copied[k] = v
+	}
+	p.jingles.mu.Lock()
+	p.jingles.rules = copied
+	p.jingles.mu.Unlock()
+	log.Printf("Jingle rules set (%d rules)", len(copied))
+}
+
+// JingleRules returns a copy of the per-genre jingle schedule.
+func (p *Pipeline) JingleRules() map[string]JingleRule {
+	p.jingles.mu.Lock()
+	defer p.jingles.mu.Unlock()
+	rules := make(map[string]JingleRule, len(p.jingles.rules))
+	for k, v := range p.jingles.rules {
+		rules[k] = v
+	}
+	return rules
+}
+
+// scheduleJingle is called as each generated track starts. If a jingle is
+// due it is queued to play after this track, or decoded in the background
+// to overlay the next crossfade.
+func (p *Pipeline) scheduleJingle(ctx context.Context, genre string) {
+	rule, ok := p.jingles.due(genre, p.clock.clock.Now())
+	if !ok {
+		return
+	}
+	info, err := p.jingles.pick()
+	if err != nil {
+		log.Printf("Jingle: %v", err)
+		return
+	}
+	info.Genre = genre // so transition rules treat it as part of the set
+	log.Printf("Jingle due: %s (%s)", info.Name, rule.Mode)
+
+	if rule.Mode == JingleBetween {
+		p.queue.addFront(info, false)
+		return
+	}
+	go func() {
+		dt, err := p.decode(ctx, info)
+		if err != nil {
+			log.Printf("Decode failed %s: %v", info.Path, err)
+			return
+		}
+		select {
+		case p.overlayCh <- dt:
+		default:
+			dt.src.Close() // one already waiting
+		}
+	}()
+}
+
+// mixOverlay adds the overlay, if one is playing, to frame and ducks the
+// music while the overlay is above the sidechain gate.
+func (p *Pipeline) mixOverlay(frame []float32) {
+	target := 1.0
+	var over []float32
+	if p.overlay != nil {
+		var ok bool
+		if over, ok = p.overlay.src.ReadFrame(); ok {
+			if framePeak(over) > overlayGate {
+				p.duckHold = int(overlayHold / FrameDuration)
+			}
+			if p.duckHold > 0 {
+				p.duckHold--
+				target = jingleDuck
+				if p.overlay.duck > 0 {
+					target = p.overlay.duck
+				}
+			}
+		} else {
+			p.overlay.src.Close()
+			p.overlay = nil
+			p.duckHold = 0
+		}
+	}
+	p.duck = rampGain(frame, p.duck, target, 1/(jingleDuckRamp.Seconds()*SampleRate))
+	for i := range over {
+		frame[i] += over[i]
+	}
+}
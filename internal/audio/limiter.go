@@ -0,0 +1,125 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+// Limiter is a look-ahead peak limiter for the float32 output bus.
+//
+// For every sample it computes the gain needed to keep the peak under the
+// ceiling, takes the minimum over the look-ahead window and smooths it
+// with a moving average of the same length. The audio is delayed by the
+// window, so the gain has fully ramped down by the time a peak comes out:
+// no sample exceeds the ceiling and there is no hard clipping. Release is
+// a slower exponential so the gain doesn't pump between transients.
+type Limiter struct {
+	ceiling float64
+	size    int     // look-ahead window in samples per channel
+	release float64 // per-sample release coefficient
+
+	delay []float32 // interleaved delay line, size*Channels
+	dpos  int
+
+	// Sliding minimum of required gains (monotonic deque over a ring).
+	minIdx []int
+	minVal []float64
+	head   int
+	count  int
+	n      int // samples processed
+
+	// Moving average of the sliding minimum.
+	box    []float64
+	bpos   int
+	boxSum float64
+
+	gain float64
+}
+
+// NewLimiter creates a limiter with the given ceiling (dBFS) and look-ahead.
+func NewLimiter(ceilingDB float64, lookahead time.Duration) *Limiter {
+	size := max(1, int(lookahead.Seconds()*SampleRate))
+	box := make([]float64, size)
+	for i := range box {
+		box[i] = 1
+	}
+	return &Limiter{
+		ceiling: math.Pow(10, ceilingDB/20),
+		size:    size,
+		release: math.Exp(-1 / (0.1 * SampleRate)), // 100ms
+		delay:   make([]float32, size*Channels),
+		minIdx:  make([]int, size),
+		minVal:  make([]float64, size),
+		box:     box,
+		boxSum:  float64(size),
+		gain:    1,
+	}
+}
+
+// SetCeiling changes the ceiling (dBFS). Samples already in the look-ahead
+// window were limited against the old ceiling, so a lower one takes hold
+// within one window.
+func (l *Limiter) SetCeiling(ceilingDB float64) {
+	l.ceiling = math.Pow(10, ceilingDB/20)
+}
+
+// Process limits one interleaved frame in place and returns it. Output is
+// delayed by the look-ahead window.
+func (l *Limiter) Process(frame []float32) []float32 {
+	for i := 0; i+Channels <= len(frame); i += Channels {
+		peak := 0.0
+		for ch := 0; ch < Channels; ch++ {
+			peak = math.Max(peak, math.Abs(float64(frame[i+ch])))
+		}
+		required := 1.0
+		if peak > l.ceiling {
+			required = l.ceiling / peak
+		}
+
+		smoothed := l.boxAverage(l.slidingMin(required))
+		if smoothed < l.gain {
+			l.gain = smoothed
+		} else {
+			l.gain = smoothed + (l.gain-smoothed)*l.release
+		}
+
+		// Swap the incoming sample pair with the delayed one.
+		for ch := 0; ch < Channels; ch++ {
+			out := l.delay[l.dpos+ch]
+			l.delay[l.dpos+ch] = frame[i+ch]
+			frame[i+ch] = float32(float64(out) * l.gain)
+		}
+		l.dpos = (l.dpos + Channels) % len(l.delay)
+	}
+	return frame
+}
+
+// slidingMin pushes v and returns the minimum of the last size values.
+func (l *Limiter) slidingMin(v float64) float64 {
+	// Drop entries that have left the window.
+	if l.count > 0 && l.minIdx[l.head] <= l.n-l.size {
+		l.head = (l.head + 1) % l.size
+		l.count--
+	}
+	// Drop entries larger than v from the back; they can never be the min.
+	for l.count > 0 {
+		back := (l.head + l.count - 1) % l.size
+		if l.minVal[back] < v {
+			break
+		}
+		l.count--
+	}
+	back := (l.head + l.count) % l.size
+	l.minIdx[back], l.minVal[back] = l.n, v
+	l.count++
+	l.n++
+	return l.minVal[l.head]
+}
+
+// boxAverage pushes v and returns the mean of the last size values.
+func (l *Limiter) boxAverage(v float64) float64 {
+	l.boxSum += v - l.box[l.bpos]
+	l.box[l.bpos] = v
+	l.bpos = (l.bpos + 1) % l.size
+	return l.boxSum / float64(l.size)
+}
@@ -0,0 +1,235 @@
+package audio
+
+import "math"
+
+// Loudness metering per ITU-R BS.1770-4 / EBU R128.
+//
+// Integrated loudness uses K-weighting, 400ms blocks with 75% overlap,
+// an absolute gate at -70 LUFS and a relative gate 10 LU below the
+// ungated mean. True peak is estimated with 4x oversampling.
+
+const (
+	loudnessFloor  = -70.0           // LUFS reported for silence (absolute gate)
+	peakFloor      = -120.0          // dBFS reported for digital silence
+	blockHop       = SampleRate / 10 // 100ms sub-block (per channel)
+	oversample     = 4
+	truePeakTaps   = 48 // FIR length for true-peak interpolation
+	relativeGateLU = -10.0
+	loudnessOffset = -0.691
+)
+
+// K-weighting filter coefficients for 48kHz (BS.1770-4, table 1 and 2).
+var (
+	kShelf = biquad{
+		b0: 1.53512485958697, b1: -2.69169618940638, b2: 1.19839281085285,
+		a1: -1.69065929318241, a2: 0.73248077421585,
+	}
+	kHighPass = biquad{
+		b0: 1.0, b1: -2.0, b2: 1.0,
+		a1: -1.99004745483398, a2: 0.99007225036621,
+	}
+)
+
+// biquad is a direct form I second-order IIR section.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// truePeakFIR holds the interpolation filter shared by all meters.
+var truePeakFIR = func() []float64 {
+	h := make([]float64, truePeakTaps)
+	center := float64(truePeakTaps-1) / 2
+	for i := range h {
+		t := (float64(i) - center) / oversample
+		sinc := 1.0
+		if t != 0 {
+			sinc = math.Sin(math.Pi*t) / (math.Pi * t)
+		}
+		window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(truePeakTaps-1))
+		h[i] = sinc * window
+	}
+	// Normalize each polyphase branch to unity DC gain.
+	for phase := 0; phase < oversample; phase++ {
+		sum := 0.0
+		for i := phase; i < truePeakTaps; i += oversample {
+			sum += h[i]
+		}
+		for i := phase; i < truePeakTaps; i += oversample {
+			h[i] /= sum
+		}
+	}
+	return h
+}()
+
+// LoudnessMeter accumulates interleaved stereo PCM and reports integrated
+// loudness and true peak. Feed it with Write in any chunk size.
+type LoudnessMeter struct {
+	shelf [Channels]biquad
+	hpf   [Channels]biquad
+
+	subSum   [Channels]float64 // sum of squares in the current 100ms sub-block
+	subCount int
+	subs     [][Channels]float64 // mean squares of the last 4 sub-blocks
+	blocks   []float64           // weighted mean square of every 400ms block
+
+	history [Channels][]float64 // last samples for true-peak interpolation
+	peak    float64             // linear true peak
+}
+
+// NewLoudnessMeter creates a meter for 48kHz stereo input.
+func NewLoudnessMeter() *LoudnessMeter {
+	m := &LoudnessMeter{}
+	for ch := 0; ch < Channels; ch++ {
+		m.shelf[ch] = kShelf
+		m.hpf[ch] = kHighPass
+		m.history[ch] = make([]float64, truePeakTaps/oversample)
+	}
+	return m
+}
+
+// Write feeds interleaved float samples (full scale ±1) into the meter.
+func (m *LoudnessMeter) Write(samples []float32) {
+	taps := truePeakTaps / oversample
+	for i := 0; i+Channels <= len(samples); i += Channels {
+		for ch := 0; ch < Channels; ch++ {
+			x := float64(samples[i+ch])
+
+			// True peak: shift history, then evaluate each polyphase branch.
+			hist := m.history[ch]
+			copy(hist[1:], hist[:taps-1])
+			hist[0] = x
+			for phase := 0; phase < oversample; phase++ {
+				var y float64
+				for j := 0; j < taps; j++ {
+					y += truePeakFIR[j*oversample+phase] * hist[j]
+				}
+				if y < 0 {
+					y = -y
+				}
+				if y > m.peak {
+					m.peak = y
+				}
+			}
+
+			k := m.hpf[ch].process(m.shelf[ch].process(x))
+			m.subSum[ch] += k * k
+		}
+
+		m.subCount++
+		if m.subCount == blockHop {
+			m.closeSubBlock()
+		}
+	}
+}
+
+// closeSubBlock finishes a 100ms sub-block and emits a 400ms gating block
+// once four sub-blocks are available (75% overlap).
+func (m *LoudnessMeter) closeSubBlock() {
+	var ms [Channels]float64
+	for ch := range ms {
+		ms[ch] = m.subSum[ch] / float64(m.subCount)
+		m.subSum[ch] = 0
+	}
+	m.subCount = 0
+
+	m.subs = append(m.subs, ms)
+	if len(m.subs) > 4 {
+		m.subs = m.subs[1:]
+	}
+	if len(m.subs) < 4 {
+		return
+	}
+	var z float64
+	for _, s := range m.subs {
+		for ch := range s {
+			z += s[ch] // channel weight G=1.0 for L/R
+		}
+	}
+	m.blocks = append(m.blocks, z/4)
+}
+
+// Integrated returns the gated integrated loudness in LUFS.
+// Returns -70 (the absolute gate) for silent or very short input.
+func (m *LoudnessMeter) Integrated() float64 {
+	abs := math.Pow(10, (loudnessFloor-loudnessOffset)/10)
+
+	var sum float64
+	var n int
+	for _, z := range m.blocks {
+		if z > abs {
+			sum += z
+			n++
+		}
+	}
+	if n == 0 {
+		return loudnessFloor
+	}
+
+	rel := sum / float64(n) * math.Pow(10, relativeGateLU/10)
+	sum, n = 0, 0
+	for _, z := range m.blocks {
+		if z > abs && z > rel {
+			sum += z
+			n++
+		}
+	}
+	if n == 0 {
+		return loudnessFloor
+	}
+	return loudnessOffset + 10*math.Log10(sum/float64(n))
+}
+
+// TruePeak returns the true peak level in dBTP.
+func (m *LoudnessMeter) TruePeak() float64 {
+	if m.peak == 0 {
+		return peakFloor
+	}
+	return 20 * math.Log10(m.peak)
+}
+
+// MeasureLoudness returns the integrated loudness (LUFS) and true peak
+// (dBTP) of interleaved stereo samples.
+func MeasureLoudness(samples []int16) (lufs, truePeak float64) {
+	m := NewLoudnessMeter()
+	m.Write(Int16ToFloat(samples))
+	return m.Integrated(), m.TruePeak()
+}
+
+// NormalizationGain returns the gain in dB that brings a track measured at
+// lufs/truePeak to targetLUFS without pushing its true peak above ceiling.
+// Silent tracks get 0 dB.
+func NormalizationGain(lufs, truePeak, targetLUFS, ceiling float64) float64 {
+	if lufs <= loudnessFloor {
+		return 0
+	}
+	gain := targetLUFS - lufs
+	if truePeak+gain > ceiling {
+		gain = ceiling - truePeak
+	}
+	return gain
+}
+
+// ApplyGain scales interleaved samples in place by gainDB, clipping to int16.
+func ApplyGain(samples []int16, gainDB float64) {
+	if gainDB == 0 {
+		return
+	}
+	g := math.Pow(10, gainDB/20)
+	for i, s := range samples {
+		v := float64(s) * g
+		if v > 32767 {
+			v = 32767
+		} else if v < -32768 {
+			v = -32768
+		}
+		samples[i] = int16(v)
+	}
+}
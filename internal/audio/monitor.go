@@ -0,0 +1,189 @@
+package audio
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Live output monitor: level and clipping over a sliding window of the
+// frames going out, with automatic skip on prolonged dead air. Dead air
+// is judged on the music bus, before the ambient overlay and master gain:
+// an ambient bed would mask it, and a low master gain would fake it.
+//
+// Clipping is counted as samples at or over full scale plus flat-topped
+// plateaus (3+ identical samples above -12 dBFS). ACE-Step's clipped
+// renders are baked into the file and scaled by normalization, so they
+// show up as plateaus below full scale rather than overs.
+
+const (
+	monitorWindow = 50   // frames (1s) for RMS, peak and clip ratio
+	plateauFloor  = 0.25 // ~-12 dBFS; quieter flat runs are not clipping
+	plateauEps    = 1e-4
+)
+
+// MonitorConfig sets the dead-air and clipping thresholds.
+type MonitorConfig struct {
+	SilenceDB      float64       // window RMS below this counts as silence (dBFS)
+	SilenceTimeout time.Duration // skip after this long of silence; 0 disables
+	ClipRatio      float64       // clip ratio above this counts as a clipping event
+}
+
+// MonitorStats is a snapshot of the monitor.
+type MonitorStats struct {
+	RMS        float64 // dBFS over the last window
+	Peak       float64 // dBFS over the last window
+	ClipRatio  float64 // fraction of clipped samples over the last window
+	DeadAir    uint64  // prolonged silences detected (each one skipped)
+	ClipEvents uint64  // windows that crossed the clip ratio threshold
+}
+
+type frameLevel struct {
+	sumSq   float64
+	peak    float64
+	clipped int
+}
+
+// Monitor watches the live output. It is fed from the pipeline goroutine
+// and read from anywhere.
+type Monitor struct {
+	mu     sync.Mutex
+	cfg    MonitorConfig
+	skip   func()
+	window [monitorWindow]frameLevel
+	pos    int
+	filled int
+	music  [monitorWindow]float64 // sum of squares per music bus frame
+	mpos   int
+	mfill  int
+
+	prev      [Channels]float32
+	run       [Channels]int
+	silent    int  // consecutive frames with a silent window
+	suspended bool // silence is intended (paused or muted), not dead air
+	clipping  bool
+	stats     MonitorStats
+}
+
+// NewMonitor creates a monitor that calls skip on prolonged silence.
+func NewMonitor(cfg MonitorConfig, skip func()) *Monitor {
+	return &Monitor{cfg: cfg, skip: skip, stats: MonitorStats{RMS: peakFloor, Peak: peakFloor}}
+}
+
+// SetConfig replaces the thresholds.
+func (m *Monitor) SetConfig(cfg MonitorConfig) {
+	m.mu.Lock()
+	m.cfg = cfg
+	m.silent = 0
+	m.mu.Unlock()
+	log.Printf("Dead-air monitor: below %.0f dBFS for %v skips", cfg.SilenceDB, cfg.SilenceTimeout)
+}
+
+// suspend turns dead-air detection off while the silence is intended.
+func (m *Monitor) suspend(on bool) {
+	m.mu.Lock()
+	m.suspended = on
+	m.silent = 0
+	m.mu.Unlock()
+}
+
+// Config returns the thresholds.
+func (m *Monitor) Config() MonitorConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cfg
+}
+
+// Stats returns the current levels and event counters.
+func (m *Monitor) Stats() MonitorStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// Observe measures one outgoing frame for the level and clipping stats.
+func (m *Monitor) Observe(frame []float32) {
+	var lvl frameLevel
+	for i, x := range frame {
+		ch := i % Channels
+		a := math.Abs(float64(x))
+		lvl.sumSq += a * a
+		lvl.peak = math.Max(lvl.peak, a)
+		if math.Abs(float64(x-m.prev[ch])) < plateauEps && a > plateauFloor {
+			m.run[ch]++
+		} else {
+			m.run[ch] = 0
+		}
+		m.prev[ch] = x
+		if a >= 1 || m.run[ch] >= 2 {
+			lvl.clipped++
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.window[m.pos] = lvl
+	m.pos = (m.pos + 1) % monitorWindow
+	m.filled = min(m.filled+1, monitorWindow)
+
+	var sumSq, peak float64
+	var clipped int
+	for _, l := range m.window[:m.filled] {
+		sumSq += l.sumSq
+		peak = math.Max(peak, l.peak)
+		clipped += l.clipped
+	}
+	samples := float64(m.filled * FrameSamples)
+	m.stats.RMS = toDB(math.Sqrt(sumSq / samples))
+	m.stats.Peak = toDB(peak)
+	m.stats.ClipRatio = float64(clipped) / samples
+
+	if clipping := m.stats.ClipRatio > m.cfg.ClipRatio; clipping != m.clipping {
+		m.clipping = clipping
+		if clipping {
+			m.stats.ClipEvents++
+			log.Printf("Clipping detected: %.1f%% of samples over the last second", m.stats.ClipRatio*100)
+		}
+	}
+}
+
+// observeMusic feeds dead-air detection with one frame of the music bus,
+// skipping the track after SilenceTimeout below SilenceDB.
+func (m *Monitor) observeMusic(frame []float32) {
+	var sumSq float64
+	for _, x := range frame {
+		sumSq += float64(x) * float64(x)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.music[m.mpos] = sumSq
+	m.mpos = (m.mpos + 1) % monitorWindow
+	m.mfill = min(m.mfill+1, monitorWindow)
+	sumSq = 0
+	for _, s := range m.music[:m.mfill] {
+		sumSq += s
+	}
+	rms := toDB(math.Sqrt(sumSq / float64(m.mfill*FrameSamples)))
+
+	if rms >= m.cfg.SilenceDB || m.cfg.SilenceTimeout <= 0 || m.suspended {
+		m.silent = 0
+		return
+	}
+	m.silent++
+	if time.Duration(m.silent)*FrameDuration >= m.cfg.SilenceTimeout {
+		m.silent = 0
+		m.stats.DeadAir++
+		log.Printf("Dead air: below %.0f dBFS for %v, skipping", m.cfg.SilenceDB, m.cfg.SilenceTimeout)
+		m.skip()
+	}
+}
+
+// toDB converts a linear level to dBFS, floored for silence.
+func toDB(v float64) float64 {
+	if v <= 0 {
+		return peakFloor
+	}
+	return math.Max(peakFloor, 20*math.Log10(v))
+}
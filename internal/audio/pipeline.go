@@ -3,57 +3,174 @@ package audio
 import (
 	"context"
 	"log"
+	"math"
 	"sync"
 	"time"
 )
 
+// decodedTrack is an analyzed track whose PCM is streamed on demand. In
+// the queue, an entry with no src is still waiting to be decoded.
 type decodedTrack struct {
-	info    TrackInfo
-	samples []int16
+	info   TrackInfo
+	src    frameSource
+	length int // remaining samples per channel from the current read position
+	beats  BeatGrid
+
+	pinned     bool // never passed by a later track in the queue
+	passedOver int  // times harmonic mixing picked a later track instead
+
+	duck float64 // music level under it as an overlay; 0 means jingleDuck
+}
+
+// frames returns the number of whole frames left in the track.
+func (dt *decodedTrack) frames() int {
+	return dt.length / FrameSize
 }
 
+// limiterLookahead is how far ahead the output limiter sees peaks coming.
+const limiterLookahead = 5 * time.Millisecond
+
+// pauseFade is how long pause and resume take to fade out and in.
+const pauseFade = 500 * time.Millisecond
+
+// masterRamp is how long master gain takes to move by full scale, so
+// volume and mute changes never step mid-waveform.
+const masterRamp = 50 * time.Millisecond
+
+// Master gain range in dB.
+const (
+	MinMasterGain = -60.0
+	MaxMasterGain = 6.0
+)
+
+// alignSlack is extra head prefetched beyond the crossfade so that
+// beat alignment can skip up to one bar without touching FFmpeg.
+const alignSlack = 3 * time.Second
+
 // Pipeline decodes tracks, applies crossfade, and outputs PCM frames at real-time rate.
 type Pipeline struct {
-	trackCh      chan TrackInfo
-	frameCh      chan []int16
+	frameCh      chan []float32
 	skipCh       chan struct{}
 	crossfadeDur time.Duration
-	decodedCh    chan *decodedTrack // exposed for queue counting
+	queue        *trackQueue // tracks waiting to play, pending or decoded
+	effects      *EffectChain
+	ambient      *Ambient
+	monitor      *Monitor
+	limiter      *Limiter   // output stage, owned by Run
+	clock        frameClock // output pacing, owned by Run
+	genreChange  float64    // FrameInfo.GenreChange for the frame being sent
+	pauseGain    float64    // pause fade level, owned by Run
+	outGain      float64    // master gain level reached, owned by Run
+	jingles      jingleScheduler
+	voice        voiceOver
+	overlayCh    chan *decodedTrack // decoded overlay jingle waiting for a crossfade
+	voiceCh      chan *decodedTrack // decoded announcement waiting for a crossfade
+	overlay      *decodedTrack      // overlay jingle or announcement on air, owned by Run
+	duck         float64            // music level under the overlay, owned by Run
+	duckHold     int                // frames the duck holds after the overlay goes quiet
 
 	mu            sync.RWMutex
+	targetLUFS    float64 // loudness normalization target
+	peakCeiling   float64 // true-peak ceiling after normalization (dBTP)
+	beatSync      bool    // align crossfades to downbeats when tempo is known
+	harmonicMix   bool    // pick the next track by key and tempo instead of FIFO
+	silence       SilenceConfig
+	standby       StandbyConfig
+	inStandby     bool
+	paused        bool
+	masterGain    float64 // dB, applied to every outgoing frame
+	muted         bool
+	transition    TransitionSpec
+	rules         map[string]TransitionSpec // "from>to" genre pair -> transition
 	currentTrack  TrackInfo
+	incoming      TrackInfo // crossfading in, once past the midpoint
 	trackPosition time.Duration
 	trackDuration time.Duration
+	trackStarted  time.Time
+	history       []HistoryEntry // oldest first
+	clockStats    ClockStats
 }
 
 // NewPipeline creates an audio pipeline with the given crossfade duration.
 func NewPipeline(crossfadeDuration time.Duration) *Pipeline {
-	return &Pipeline{
-		trackCh:      make(chan TrackInfo, 8),
-		frameCh:      make(chan []int16, 100),
+	p := &Pipeline{
+		frameCh:      make(chan []float32, 100),
 		skipCh:       make(chan struct{}, 1),
 		crossfadeDur: crossfadeDuration,
-		decodedCh:    make(chan *decodedTrack, 4),
+		queue:        newTrackQueue(4),
+		targetLUFS:   -14,
+		peakCeiling:  -1,
+		beatSync:     true,
+		silence:      SilenceConfig{ThresholdDB: -50, MinDuration: 300 * time.Millisecond},
+		standby:      StandbyConfig{Mode: StandbyReplay},
+		pauseGain:    1,
+		overlayCh:    make(chan *decodedTrack, 1),
+		voiceCh:      make(chan *decodedTrack, 1),
+		duck:         1,
+		outGain:      1,
+		transition:   DefaultTransition,
+		rules:        make(map[string]TransitionSpec),
+		clock:        frameClock{clock: realClock{}},
+		effects:      NewEffectChain(),
+		ambient:      NewAmbient(),
 	}
+	p.monitor = NewMonitor(MonitorConfig{SilenceDB: -60, SilenceTimeout: 10 * time.Second, ClipRatio: 0.01}, p.Skip)
+	return p
 }
 
-// Frames returns the channel of outgoing PCM frames (20ms each).
-func (p *Pipeline) Frames() <-chan []int16 {
+// Frames returns the channel of outgoing PCM frames (20ms each). Samples
+// are float32 at full scale ±1 and already limited below the ceiling;
+// consumers convert to int16 with FloatToInt16 at the encoder boundary.
+func (p *Pipeline) Frames() <-chan []float32 {
 	return p.frameCh
 }
 
 // Enqueue adds a track to the pipeline's playback queue.
 func (p *Pipeline) Enqueue(t TrackInfo) {
-	p.trackCh <- t
+	p.queue.add(t)
 }
 
-// QueueSize returns the total number of tracks waiting (pending + decoded).
+// QueueSize returns the number of generated tracks waiting (pending +
+// decoded). Queued jingles are not counted.
 func (p *Pipeline) QueueSize() int {
-	n := len(p.trackCh)
-	if p.decodedCh != nil {
-		n += len(p.decodedCh)
+	return p.queue.len()
+}
+
+// Queue returns the tracks waiting to play, in queue order. With harmonic
+// mixing on, decoded tracks up to the first pinned one may play in a
+// different order.
+func (p *Pipeline) Queue() []QueuedTrack {
+	return p.queue.list()
+}
+
+// RemoveQueued drops a track from the queue before it airs.
+func (p *Pipeline) RemoveQueued(id string) error {
+	if err := p.queue.remove(id); err != nil {
+		return err
+	}
+	log.Printf("Removed from queue: %s", id)
+	return nil
+}
+
+// PlayNext moves a queued track to the front of the queue. A track that
+// is still pending is decoded next, and plays next if it is ready in time.
+func (p *Pipeline) PlayNext(id string) error {
+	if err := p.queue.moveToFront(id); err != nil {
+		return err
+	}
+	log.Printf("Playing next: %s", id)
+	return nil
+}
+
+// PinQueued pins or unpins a queued track. A pinned track plays at its
+// place in the queue: harmonic mixing never picks a later track ahead of
+// it, and the player waits for it to decode rather than skip past it.
+func (p *Pipeline) PinQueued(id string, pinned bool) error {
+	if err := p.queue.setPinned(id, pinned); err != nil {
+		return err
 	}
-	return n
+	log.Printf("Queue pin %s: %v", id, pinned)
+	return nil
 }
 
 // Skip interrupts the current track.
@@ -64,6 +181,80 @@ func (p *Pipeline) Skip() {
 	}
 }
 
+// Pause fades the station out and holds playback where it is. Frames of
+// digital silence keep flowing so listeners stay connected.
+func (p *Pipeline) Pause() {
+	p.setPaused(true)
+}
+
+// Resume fades playback back in from where it was paused.
+func (p *Pipeline) Resume() {
+	p.setPaused(false)
+}
+
+func (p *Pipeline) setPaused(paused bool) {
+	p.mu.Lock()
+	changed := p.paused != paused
+	p.paused = paused
+	silenced := p.paused || p.muted
+	p.mu.Unlock()
+	if changed {
+		p.monitor.suspend(silenced)
+		log.Printf("Station paused: %v", paused)
+	}
+}
+
+// Paused reports whether the station is paused.
+func (p *Pipeline) Paused() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.paused
+}
+
+// SetMasterGain sets the output level in dB, clamped to
+// [MinMasterGain, MaxMasterGain]. Boosts are caught by the limiter.
+func (p *Pipeline) SetMasterGain(db float64) {
+	db = math.Max(MinMasterGain, math.Min(MaxMasterGain, db))
+	p.mu.Lock()
+	p.masterGain = db
+	p.mu.Unlock()
+	log.Printf("Master gain set to %+.1f dB", db)
+}
+
+// MasterGain returns the output level in dB.
+func (p *Pipeline) MasterGain() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.masterGain
+}
+
+// SetMute mutes or unmutes the output. Unlike Pause, playback continues.
+func (p *Pipeline) SetMute(muted bool) {
+	p.mu.Lock()
+	p.muted = muted
+	silenced := p.paused || p.muted
+	p.mu.Unlock()
+	p.monitor.suspend(silenced) // intended silence is not dead air
+	log.Printf("Muted: %v", muted)
+}
+
+// Muted reports whether the output is muted.
+func (p *Pipeline) Muted() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.muted
+}
+
+// masterTarget returns the linear master gain to ramp towards.
+func (p *Pipeline) masterTarget() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.muted {
+		return 0
+	}
+	return math.Pow(10, p.masterGain/20)
+}
+
 // SetCrossfade updates the crossfade duration for future tracks.
 func (p *Pipeline) SetCrossfade(d time.Duration) {
 	p.mu.Lock()
@@ -79,6 +270,182 @@ func (p *Pipeline) CrossfadeDuration() time.Duration {
 	return p.crossfadeDur
 }
 
+// SetLoudnessTarget sets the integrated loudness target (LUFS) and true-peak
+// ceiling (dBTP) used to normalize tracks decoded from now on.
+func (p *Pipeline) SetLoudnessTarget(lufs, ceiling float64) {
+	p.mu.Lock()
+	p.targetLUFS = lufs
+	p.peakCeiling = ceiling
+	p.mu.Unlock()
+	log.Printf("Loudness target set to %.1f LUFS (ceiling %.1f dBTP)", lufs, ceiling)
+}
+
+// LoudnessTarget returns the normalization target (LUFS) and ceiling (dBTP).
+func (p *Pipeline) LoudnessTarget() (lufs, ceiling float64) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.targetLUFS, p.peakCeiling
+}
+
+// SetBeatSync enables or disables downbeat-aligned crossfades.
+func (p *Pipeline) SetBeatSync(enabled bool) {
+	p.mu.Lock()
+	p.beatSync = enabled
+	p.mu.Unlock()
+	log.Printf("Beat-synced crossfades: %v", enabled)
+}
+
+// BeatSync reports whether crossfades are aligned to downbeats.
+func (p *Pipeline) BeatSync() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.beatSync
+}
+
+// SetHarmonicMix enables or disables harmonic mixing: instead of playing
+// decoded tracks in order, the next track is the one whose key and tempo
+// follow most smoothly from the current one.
+func (p *Pipeline) SetHarmonicMix(enabled bool) {
+	p.mu.Lock()
+	p.harmonicMix = enabled
+	p.mu.Unlock()
+	log.Printf("Harmonic mixing: %v", enabled)
+}
+
+// HarmonicMix reports whether decoded tracks are reordered by key and tempo.
+func (p *Pipeline) HarmonicMix() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.harmonicMix
+}
+
+// SetSilenceTrim configures leading/trailing silence trimming for tracks
+// decoded from now on.
+func (p *Pipeline) SetSilenceTrim(cfg SilenceConfig) {
+	p.mu.Lock()
+	p.silence = cfg
+	p.mu.Unlock()
+	log.Printf("Silence trim: below %.0f dBFS for %v", cfg.ThresholdDB, cfg.MinDuration)
+}
+
+// SilenceTrim returns the silence trimming configuration.
+func (p *Pipeline) SilenceTrim() SilenceConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.silence
+}
+
+// SetStandby configures the bed played when the queue runs dry.
+func (p *Pipeline) SetStandby(cfg StandbyConfig) {
+	p.mu.Lock()
+	p.standby = cfg
+	p.mu.Unlock()
+	log.Printf("Standby bed: %s", cfg.Mode)
+}
+
+// Standby returns the standby bed configuration.
+func (p *Pipeline) Standby() StandbyConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.standby
+}
+
+// InStandby reports whether the standby bed is on air.
+func (p *Pipeline) InStandby() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.inStandby
+}
+
+func (p *Pipeline) setStandby(on bool) {
+	p.mu.Lock()
+	p.inStandby = on
+	p.mu.Unlock()
+}
+
+// SetTransition sets the default transition style and curve.
+func (p *Pipeline) SetTransition(spec TransitionSpec) {
+	p.mu.Lock()
+	p.transition = spec
+	p.mu.Unlock()
+	log.Printf("Transition set to %s", spec)
+}
+
+// Transition returns the default transition.
+func (p *Pipeline) Transition() TransitionSpec {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.transition
+}
+
+// SetTransitionRules replaces the per-genre transition rules. Keys are
+// TransitionKey(from, to); either genre may be "*".
+func (p *Pipeline) SetTransitionRules(rules map[string]TransitionSpec) {
+	copied := make(map[string]TransitionSpec, len(rules))
+	for k, v := range rules {
+		copied[k] = v
+	}
+	p.mu.Lock()
+	p.rules = copied
+	p.mu.Unlock()
+	log.Printf("Transition rules set (%d rules)", len(copied))
+}
+
+// TransitionRules returns a copy of the per-genre transition rules.
+func (p *Pipeline) TransitionRules() map[string]TransitionSpec {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	rules := make(map[string]TransitionSpec, len(p.rules))
+	for k, v := range p.rules {
+		rules[k] = v
+	}
+	return rules
+}
+
+// transitionFor picks the transition between two genres: an exact rule
+// first, then "from>*", then "*>to", then the default.
+func (p *Pipeline) transitionFor(from, to string) TransitionSpec {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, key := range []string{
+		TransitionKey(from, to),
+		TransitionKey(from, "*"),
+		TransitionKey("*", to),
+	} {
+		if spec, ok := p.rules[key]; ok {
+			return spec
+		}
+	}
+	return p.transition
+}
+
+// Effects returns the output effects chain, for runtime adjustment.
+func (p *Pipeline) Effects() *EffectChain {
+	return p.effects
+}
+
+// Ambient returns the ambient overlay layer.
+func (p *Pipeline) Ambient() *Ambient {
+	return p.ambient
+}
+
+// Monitor returns the live output monitor.
+func (p *Pipeline) Monitor() *Monitor {
+	return p.monitor
+}
+
+// SetClock replaces the clock that paces output. Must be called before Run.
+func (p *Pipeline) SetClock(c Clock) {
+	p.clock = frameClock{clock: c, deadline: c.Now()}
+}
+
+// ClockStats returns frame timing statistics.
+func (p *Pipeline) ClockStats() ClockStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.clockStats
+}
+
 // Status returns current playback info.
 func (p *Pipeline) Status() (track TrackInfo, position, duration time.Duration) {
 	p.mu.RLock()
@@ -86,34 +453,42 @@ func (p *Pipeline) Status() (track TrackInfo, position, duration time.Duration)
 	return p.currentTrack, p.trackPosition, p.trackDuration
 }
 
+// OnAir returns the track listeners hear most of, for now-playing
+// displays: the incoming track from the midpoint of a crossfade, and the
+// last generated track while a jingle plays.
+func (p *Pipeline) OnAir() TrackInfo {
+	p.mu.RLock()
+	incoming := p.incoming
+	p.mu.RUnlock()
+	if incoming.ID != "" && !incoming.Interstitial {
+		return incoming
+	}
+	return p.lastMusic()
+}
+
 // Run starts the pipeline. Blocks until ctx is cancelled.
 func (p *Pipeline) Run(ctx context.Context) {
 	defer close(p.frameCh)
 
-	ticker := time.NewTicker(FrameDuration)
-	defer ticker.Stop()
+	p.clock.deadline = p.clock.clock.Now()
+	_, ceiling := p.LoudnessTarget()
+	p.limiter = NewLimiter(ceiling, limiterLookahead)
 
-	// Background decoder: converts file paths to decoded PCM
+	// Background decoder: analyzes pending tracks in queue order and opens
+	// their streams
 	go func() {
-		defer close(p.decodedCh)
+		defer p.queue.close()
 		for {
-			select {
-			case <-ctx.Done():
+			pending, ok := p.queue.nextPending(ctx)
+			if !ok {
 				return
-			case t, ok := <-p.trackCh:
-				if !ok {
-					return
-				}
-				samples, err := DecodeFile(t.Path)
-				if err != nil {
-					log.Printf("Decode failed %s: %v", t.Path, err)
-					continue
-				}
-				select {
-				case p.decodedCh <- &decodedTrack{info: t, samples: samples}:
-				case <-ctx.Done():
-					return
-				}
+			}
+			dt, err := p.decode(ctx, pending.info)
+			if err != nil {
+				log.Printf("Decode failed %s: %v", pending.info.Path, err)
+			}
+			if !p.queue.ready(pending, dt) && dt != nil {
+				dt.src.Close() // removed while decoding
 			}
 		}
 	}()
@@ -129,19 +504,16 @@ func (p *Pipeline) Run(ctx context.Context) {
 			dt = pending
 			pending = nil
 		} else {
-			select {
-			case <-ctx.Done():
+			var ok bool
+			if dt, startFrame, ok = p.waitTrack(ctx); !ok {
 				return
-			case d, ok := <-p.decodedCh:
-				if !ok {
-					return
-				}
-				dt = d
-				startFrame = 0
 			}
 		}
 
-		next, nextStart := p.playTrack(ctx, ticker, p.decodedCh, dt, startFrame)
+		next, nextStart := p.playTrack(ctx, dt, startFrame)
+		if ctx.Err() == nil {
+			p.recordHistory()
+		}
 		if next != nil {
 			pending = next
 			startFrame = nextStart
@@ -151,66 +523,192 @@ func (p *Pipeline) Run(ctx context.Context) {
 	}
 }
 
+// nextTrack takes the next decoded track to follow current: the head of
+// the queue, or with harmonic mixing the best match among the waiting
+// tracks. Returns nil if none is ready; closed reports that none will be.
+func (p *Pipeline) nextTrack(current TrackInfo) (dt *decodedTrack, closed bool) {
+	if !p.HarmonicMix() {
+		return p.queue.pop(nil)
+	}
+	pick := harmonicPick(current)
+	return p.queue.pop(func(items []*decodedTrack) int {
+		i := pick(items)
+		if i > 0 {
+			log.Printf("Harmonic mix: %s (%s, %.0f BPM) ahead of %s (%s, %.0f BPM)",
+				items[i].info.ID, items[i].info.Key.Camelot(), items[i].info.BPM,
+				items[0].info.ID, items[0].info.Key.Camelot(), items[0].info.BPM)
+		}
+		return i
+	})
+}
+
 // playTrack plays a decoded track with crossfade into the next one if available.
 // Returns the next decoded track and starting frame if a crossfade occurred.
-func (p *Pipeline) playTrack(ctx context.Context, ticker *time.Ticker, decodedCh <-chan *decodedTrack, dt *decodedTrack, startFrame int) (*decodedTrack, int) {
-	samples := dt.samples
-	totalFrames := len(samples) / FrameSamples
+// Frames are pulled from the track's stream as they are sent; dt's stream is
+// closed before returning.
+func (p *Pipeline) playTrack(ctx context.Context, dt *decodedTrack, startFrame int) (*decodedTrack, int) {
+	defer dt.src.Close()
+
+	totalFrames := startFrame + dt.frames()
 	cfFrames := int(p.CrossfadeDuration().Seconds()) * SampleRate / FrameSize
 	if cfFrames > totalFrames/2 {
 		cfFrames = totalFrames / 2 // don't crossfade more than half the track
 	}
 	cfStart := totalFrames - cfFrames
 
+	// Beat sync: start the crossfade on the last downbeat before the default
+	// start. beatOffset is where that downbeat falls inside its frame.
+	beatOffset := -1
+	if p.BeatSync() {
+		if db := dt.beats.PrevDownbeat(cfStart * FrameSize); db >= startFrame*FrameSize {
+			cfStart = db / FrameSize
+			beatOffset = db - cfStart*FrameSize
+		}
+	}
+
+	dt.info.FadeIn = time.Duration(startFrame) * FrameDuration
+	dt.info.FadeOut = time.Duration(totalFrames-cfStart) * FrameDuration
 	p.setTrack(dt.info, totalFrames)
 	log.Printf("Now playing: %s (genre: %s, frames: %d)", dt.info.ID, dt.info.Genre, totalFrames)
+	if !dt.info.Interstitial {
+		p.scheduleJingle(ctx, dt.info.Genre)
+		p.scheduleVoice(ctx, dt.info)
+	}
 
 	// Play pre-crossfade frames
 	for i := startFrame; i < cfStart; i++ {
-		if !p.sendFrame(ctx, ticker, samples[i*FrameSamples:(i+1)*FrameSamples]) {
+		frame, ok := dt.src.ReadFrame()
+		if !ok {
+			return nil, 0 // stream ended early
+		}
+		if !p.sendFrame(ctx, frame) {
 			return nil, 0
 		}
 		p.updatePosition(i)
 	}
 
 	// Try to get next decoded track for crossfade
-	var next *decodedTrack
-	select {
-	case d := <-decodedCh:
-		next = d
-	default:
-	}
+	next, _ := p.nextTrack(dt.info)
 
 	if next != nil {
+		// A short incoming track (a jingle between tracks) gets at most half
+		// its length as crossfade, so the outgoing one plays alone until the
+		// shortened crossfade is due and both still end together. The
+		// crossfade no longer starts on the downbeat, so it isn't aligned.
+		if n := next.frames() / 2; cfFrames > n {
+			end := cfStart + cfFrames
+			for i := cfStart; i < end-n; i++ {
+				frame, ok := dt.src.ReadFrame()
+				if !ok {
+					break // the crossfade below hands over to next
+				}
+				if !p.sendFrame(ctx, frame) {
+					next.src.Close()
+					return nil, 0
+				}
+				p.updatePosition(i)
+			}
+			cfStart, cfFrames = end-n, n
+			beatOffset = -1
+			p.mu.Lock()
+			p.currentTrack.FadeOut = time.Duration(totalFrames-cfStart) * FrameDuration
+			p.mu.Unlock()
+		}
+
+		if beatOffset >= 0 {
+			if skip, ok := alignIncoming(next, beatOffset); ok {
+				log.Printf("Beat-aligned crossfade: %.1f -> %.1f BPM (incoming offset %dms)",
+					dt.info.BPM, next.info.BPM, skip*1000/SampleRate)
+			}
+		}
+
+		spec := p.transitionFor(dt.info.Genre, next.info.Genre)
+		transition := spec.New()
+		genreChange := dt.info.Genre != next.info.Genre
+		defer func() { p.genreChange = 0 }()
+
+		// An announcement waiting to air starts with the crossfade, unless it
+		// would talk over a jingle; otherwise an overlay jingle ends with it.
+		var overlay *decodedTrack
+		overlayStart := -1
+		waiting := p.overlayCh
+		if !next.info.Interstitial {
+			select {
+			case overlay = <-p.voiceCh:
+				overlayStart, waiting = 0, p.voiceCh
+			default:
+			}
+		}
+		if overlay == nil {
+			select {
+			case overlay = <-p.overlayCh:
+				overlayStart = max(0, cfFrames-overlay.frames())
+			default:
+			}
+		}
+		if overlay != nil {
+			defer func() {
+				if p.overlay != overlay { // crossfade cut short: keep it for the next one
+					select {
+					case waiting <- overlay:
+					default:
+						overlay.src.Close()
+					}
+				}
+			}()
+		}
+
 		// Crossfade zone: blend outgoing with incoming
+		mixed := 0
 		for i := 0; i < cfFrames; i++ {
-			outPos := (cfStart + i) * FrameSamples
-			inPos := i * FrameSamples
-
-			if outPos+FrameSamples > len(samples) || inPos+FrameSamples > len(next.samples) {
+			outFrame, ok := dt.src.ReadFrame()
+			if !ok {
 				break
 			}
+			inFrame, ok := next.src.ReadFrame()
+			if ok {
+				mixed++
+			} else {
+				inFrame = make([]float32, len(outFrame)) // finish the outgoing fade
+			}
+			if i == overlayStart {
+				if p.overlay != nil {
+					p.overlay.src.Close()
+				}
+				p.overlay = overlay
+				log.Printf("Over crossfade: %s", overlay.info.Name)
+			}
+
+			if i == cfFrames/2 {
+				p.setIncoming(next.info)
+			}
 
 			progress := float64(i) / float64(cfFrames)
-			frame := CrossfadeFrames(
-				samples[outPos:outPos+FrameSamples],
-				next.samples[inPos:inPos+FrameSamples],
-				progress,
-			)
+			frame := transition.Mix(outFrame, inFrame, progress)
+			if genreChange {
+				p.genreChange = math.Sin(progress * math.Pi)
+			}
 
-			if !p.sendFrame(ctx, ticker, frame) {
+			if !p.sendFrame(ctx, frame) {
+				next.src.Close()
+				p.setIncoming(TrackInfo{})
 				return nil, 0
 			}
 			p.updatePosition(cfStart + i)
 		}
 
-		log.Printf("Crossfaded into: %s (genre: %s)", next.info.ID, next.info.Genre)
-		return next, cfFrames
+		next.length -= mixed * FrameSize
+		log.Printf("Crossfaded into: %s (genre: %s, transition: %s)", next.info.ID, next.info.Genre, spec)
+		return next, mixed
 	}
 
 	// No next track available: play remaining frames without crossfade
 	for i := cfStart; i < totalFrames; i++ {
-		if !p.sendFrame(ctx, ticker, samples[i*FrameSamples:(i+1)*FrameSamples]) {
+		frame, ok := dt.src.ReadFrame()
+		if !ok {
+			break
+		}
+		if !p.sendFrame(ctx, frame) {
 			return nil, 0
 		}
 		p.updatePosition(i)
@@ -219,31 +717,188 @@ func (p *Pipeline) playTrack(ctx context.Context, ticker *time.Ticker, decodedCh
 	return nil, 0
 }
 
-// sendFrame waits for the ticker then sends a frame. Returns false on skip or cancel.
-func (p *Pipeline) sendFrame(ctx context.Context, ticker *time.Ticker, frame []int16) bool {
+// alignIncoming drops the head of the incoming track so that its first
+// downbeat lands beatOffset samples into the crossfade, on top of the
+// outgoing track's downbeat. Returns the number of samples (per channel)
+// dropped, or false if the incoming track has no confident tempo.
+func alignIncoming(next *decodedTrack, beatOffset int) (int, bool) {
+	db := next.beats.NextDownbeat(beatOffset)
+	if db < 0 {
+		return 0, false
+	}
+	skip := db - beatOffset
+	if skip >= next.length {
+		return 0, false
+	}
+	next.src.Skip(skip)
+	next.length -= skip
+	next.info.TrimStart += samplesToDuration(skip)
+	next.beats = next.beats.Shift(skip)
+	return skip, true
+}
+
+// sendFrame waits until the frame is due, runs it through the effects
+// chain and limiter, and sends it past the monitor. Returns false on skip
+// or cancel.
+func (p *Pipeline) sendFrame(ctx context.Context, frame []float32) bool {
+	if p.pauseGain == 0 && p.Paused() {
+		// Faded out: hold this frame back and send silence until resumed.
+		for p.Paused() {
+			if !p.emit(ctx, make([]float32, FrameSamples)) {
+				return false
+			}
+		}
+	}
+	if p.overlay != nil || p.duck != 1 {
+		p.mixOverlay(frame)
+	}
+	target := 1.0
+	if p.Paused() {
+		target = 0
+	}
+	if p.pauseGain != 1 || target != 1 {
+		p.pauseGain = rampGain(frame, p.pauseGain, target, 1/(pauseFade.Seconds()*SampleRate))
+	}
+	return p.emit(ctx, frame)
+}
+
+// emit paces one frame, runs it through the output stage and sends it.
+// Returns false if the track was skipped or ctx cancelled.
+func (p *Pipeline) emit(ctx context.Context, frame []float32) bool {
+	// Check first: a frame that is already due (catching up after a stall)
+	// doesn't wait, but a pending skip must still win.
 	select {
 	case <-ctx.Done():
 		return false
 	case <-p.skipCh:
 		log.Println("Track skipped")
 		return false
-	case <-ticker.C:
+	default:
+	}
+	if due := p.clock.wait(); due != nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-p.skipCh:
+			log.Println("Track skipped")
+			return false
+		case <-due:
+		}
 	}
 
+	p.effects.Process(frame, FrameInfo{GenreChange: p.genreChange})
+	p.monitor.observeMusic(frame)
+	p.ambient.mix(frame, p.Paused())
+	if target := p.masterTarget(); p.outGain != 1 || target != 1 {
+		p.outGain = rampGain(frame, p.outGain, target, 1/(masterRamp.Seconds()*SampleRate))
+	}
+	if p.limiter != nil {
+		_, ceiling := p.LoudnessTarget()
+		p.limiter.SetCeiling(ceiling)
+		frame = p.limiter.Process(frame)
+	}
+	p.monitor.Observe(frame)
+
 	select {
 	case p.frameCh <- frame:
-		return true
 	case <-ctx.Done():
 		return false
 	}
+	p.frameSent()
+	return true
+}
+
+// rampGain scales frame by a gain moving from g towards target by step per
+// sample frame, and returns the gain reached.
+func rampGain(frame []float32, g, target, step float64) float64 {
+	for i := 0; i+Channels <= len(frame); i += Channels {
+		if g < target {
+			g = math.Min(target, g+step)
+		} else {
+			g = math.Max(target, g-step)
+		}
+		for ch := 0; ch < Channels; ch++ {
+			frame[i+ch] *= float32(g)
+		}
+	}
+	return g
+}
+
+// frameSent advances the schedule and records how late the frame was.
+func (p *Pipeline) frameSent() {
+	late, resync := p.clock.sent()
+	if resync {
+		log.Printf("Clock stalled for %v, resyncing", late.Round(time.Millisecond))
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &p.clockStats
+	s.Frames++
+	s.LastLate = late
+	s.MaxLate = max(s.MaxLate, late)
+	if late > FrameDuration {
+		s.Late++
+	}
+	if resync {
+		s.Resyncs++
+	}
+}
+
+// decode analyzes a track in one streaming pass (loudness, tempo, length,
+// silence), then opens a playback stream with the normalization gain
+// applied, skips leading silence, and prefetches enough of its head to
+// crossfade into it. Trailing silence is cut by shortening the length.
+func (p *Pipeline) decode(ctx context.Context, t TrackInfo) (*decodedTrack, error) {
+	a, err := AnalyzeFile(ctx, t.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	target, ceiling := p.LoudnessTarget()
+	t.Loudness, t.TruePeak = a.Loudness, a.TruePeak
+	t.Gain = NormalizationGain(t.Loudness, t.TruePeak, target, ceiling)
+	log.Printf("Loudness %s: %.1f LUFS, %.1f dBTP, gain %+.1f dB", t.ID, t.Loudness, t.TruePeak, t.Gain)
+	if a.Beats.Confident() {
+		t.BPM = a.Beats.BPM
+		log.Printf("Tempo %s: %.1f BPM (confidence %.2f)", t.ID, a.Beats.BPM, a.Beats.Confidence)
+	}
+
+	t.Key, t.Centroid = a.Key, a.Centroid
+	log.Printf("Features %s: key %q (score %.2f), centroid %.0f Hz", t.ID, t.Key, a.KeyScore, t.Centroid)
+
+	start, end := trimBounds(a.levels, a.Samples, t.Gain, p.SilenceTrim())
+	t.TrimStart, t.TrimEnd = samplesToDuration(start), samplesToDuration(end)
+	t.Energy = energyCurve(a.levels[start/levelHop:min(len(a.levels), end/levelHop)], t.Gain)
+	t.Waveform = waveform(a.peaks, a.levels, a.Samples, t.Gain, waveformBuckets)
+	if start > 0 || end < a.Samples {
+		log.Printf("Trimmed %s: %v leading, %v trailing silence", t.ID,
+			t.TrimStart.Round(time.Millisecond), samplesToDuration(a.Samples-end).Round(time.Millisecond))
+	}
+
+	s, err := OpenStream(ctx, t.Path, t.Gain)
+	if err != nil {
+		return nil, err
+	}
+	s.Skip(start)
+	s.Prefetch(int((p.CrossfadeDuration() + alignSlack) / FrameDuration))
+
+	return &decodedTrack{info: t, src: s, length: end - start, beats: a.Beats.Shift(start)}, nil
 }
 
 func (p *Pipeline) setTrack(info TrackInfo, totalFrames int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.currentTrack = info
+	p.incoming = TrackInfo{}
 	p.trackPosition = 0
 	p.trackDuration = time.Duration(totalFrames) * FrameDuration
+	p.trackStarted = p.clock.clock.Now()
+}
+
+func (p *Pipeline) setIncoming(info TrackInfo) {
+	p.mu.Lock()
+	p.incoming = info
+	p.mu.Unlock()
 }
 
 func (p *Pipeline) updatePosition(frameIdx int) {
@@ -0,0 +1,359 @@
+package audio
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// trackQueue holds the tracks waiting to play, in order. Entries are
+// pending (just a TrackInfo) until the decoder goroutine analyzes them and
+// opens their stream. Decoding runs in queue order and stops while
+// capacity entries are decoded, since each one holds an FFmpeg process
+// and its prefetched head. The player takes decoded entries, possibly out
+// of order, and the API can list, remove, move and pin any entry.
+type trackQueue struct {
+	mu       sync.Mutex
+	items    []*decodedTrack
+	capacity int // max decoded entries
+	closed   bool
+	playable chan struct{} // signalled when the player may have a track
+	changed  chan struct{} // signalled when the decoder may have work
+}
+
+func newTrackQueue(capacity int) *trackQueue {
+	return &trackQueue{
+		capacity: capacity,
+		playable: make(chan struct{}, 1),
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// notify wakes the decoder and the player after a change.
+func (q *trackQueue) notify() {
+	signal(q.changed)
+	signal(q.playable)
+}
+
+// add appends a pending track.
+func (q *trackQueue) add(t TrackInfo) {
+	q.mu.Lock()
+	q.items = append(q.items, &decodedTrack{info: t})
+	q.mu.Unlock()
+	q.notify()
+}
+
+// addFront inserts a pending track at the front of the queue.
+func (q *trackQueue) addFront(t TrackInfo, pinned bool) {
+	q.mu.Lock()
+	q.items = append([]*decodedTrack{{info: t, pinned: pinned}}, q.items...)
+	q.mu.Unlock()
+	q.notify()
+}
+
+// nextPending waits for the first pending entry while fewer than capacity
+// entries are decoded. When nothing ahead of the first pinned entry is
+// decoded, the entries up to it are decoded regardless: the player can't
+// pass a pinned track and would otherwise wait on it forever. Returns
+// false if ctx is cancelled first.
+func (q *trackQueue) nextPending(ctx context.Context) (*decodedTrack, bool) {
+	for {
+		q.mu.Lock()
+		decoded := 0
+		var next *decodedTrack
+		for _, dt := range q.items {
+			if dt.src != nil {
+				decoded++
+			} else if next == nil {
+				next = dt
+			}
+		}
+		stuck := false // the player is waiting on a pinned entry
+		for _, dt := range q.items {
+			if dt.src != nil {
+				break
+			}
+			if dt.pinned {
+				stuck = true
+				break
+			}
+		}
+		if next != nil && (stuck || decoded < q.capacity) {
+			q.mu.Unlock()
+			return next, true
+		}
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-q.changed:
+		}
+	}
+}
+
+// ready replaces the pending entry with its decoded track, keeping its
+// place and pin, or drops it if decoding failed (dt is nil). Returns false
+// if the entry was removed in the meantime; the caller then closes dt.
+func (q *trackQueue) ready(pending, dt *decodedTrack) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := q.index(pending)
+	if i < 0 {
+		return false
+	}
+	if dt == nil {
+		q.items = append(q.items[:i], q.items[i+1:]...)
+	} else {
+		dt.pinned = pending.pinned
+		q.items[i] = dt
+	}
+	q.notify()
+	return true
+}
+
+func (q *trackQueue) index(dt *decodedTrack) int {
+	for i, d := range q.items {
+		if d == dt {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *trackQueue) find(id string) int {
+	for i, d := range q.items {
+		if d.info.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// pop removes and returns the decoded entry chosen by pick (an index into
+// the candidates, which are never empty). Candidates are the decoded
+// entries up to the first pinned one, so nothing jumps a pinned track; a
+// nil pick takes the first. Returns nil if no candidate is decoded yet;
+// closed reports that the queue is closed and none will be.
+func (q *trackQueue) pop(pick func([]*decodedTrack) int) (dt *decodedTrack, closed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var candidates []*decodedTrack
+	for _, d := range q.items {
+		if d.src != nil {
+			candidates = append(candidates, d)
+		}
+		if d.pinned {
+			break
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, q.closed
+	}
+	i := 0
+	if pick != nil {
+		i = pick(candidates)
+	}
+	dt = candidates[i]
+	j := q.index(dt)
+	q.items = append(q.items[:j], q.items[j+1:]...)
+	q.notify()
+	return dt, false
+}
+
+// wait returns a channel that is signalled when an entry may be playable.
+func (q *trackQueue) wait() <-chan struct{} {
+	return q.playable
+}
+
+// len returns the number of generated tracks waiting, not counting
+// interstitials.
+func (q *trackQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, dt := range q.items {
+		if !dt.info.Interstitial {
+			n++
+		}
+	}
+	return n
+}
+
+// close marks the queue as finished; decoded entries can still be popped.
+func (q *trackQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.notify()
+}
+
+// QueuedTrack is a snapshot of one queue entry.
+type QueuedTrack struct {
+	Track   TrackInfo // analysis fields are set once decoded
+	Decoded bool      // analyzed and ready to play
+	Pinned  bool      // plays at its place in the queue, never passed by another track
+}
+
+// list returns a snapshot of the queue in order.
+func (q *trackQueue) list() []QueuedTrack {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := make([]QueuedTrack, len(q.items))
+	for i, dt := range q.items {
+		entries[i] = QueuedTrack{Track: dt.info, Decoded: dt.src != nil, Pinned: dt.pinned}
+	}
+	return entries
+}
+
+// remove drops the track with the given ID, stopping its stream if it
+// was decoded.
+func (q *trackQueue) remove(id string) error {
+	q.mu.Lock()
+	i := q.find(id)
+	if i < 0 {
+		q.mu.Unlock()
+		return fmt.Errorf("track %q not in queue", id)
+	}
+	dt := q.items[i]
+	q.items = append(q.items[:i], q.items[i+1:]...)
+	q.mu.Unlock()
+	q.notify()
+	if dt.src != nil {
+		dt.src.Close()
+	}
+	return nil
+}
+
+// moveToFront makes the track with the given ID the next to play (and,
+// if still pending, the next to decode).
+func (q *trackQueue) moveToFront(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := q.find(id)
+	if i < 0 {
+		return fmt.Errorf("track %q not in queue", id)
+	}
+	dt := q.items[i]
+	copy(q.items[1:i+1], q.items[:i])
+	q.items[0] = dt
+	q.notify()
+	return nil
+}
+
+// setPinned pins or unpins the track with the given ID.
+func (q *trackQueue) setPinned(id string, pinned bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := q.find(id)
+	if i < 0 {
+		return fmt.Errorf("track %q not in queue", id)
+	}
+	q.items[i].pinned = pinned
+	q.notify()
+	return nil
+}
+
+// Harmonic mixing: instead of FIFO, play the waiting track that follows
+// most smoothly from the current one.
+
+// maxPassOver is how many times a track can be passed over by harmonic
+// mixing before it plays next regardless.
+const maxPassOver = 2
+
+// Camelot returns the key's position on the Camelot wheel, e.g. "8A" for
+// A minor, or "" if unknown.
+func (k Key) Camelot() string {
+	if !k.Known() {
+		return ""
+	}
+	n, letter := k.camelot()
+	return fmt.Sprintf("%d%c", n, letter)
+}
+
+// camelot returns the wheel number (1-12) and letter (A minor, B major).
+// Adjacent numbers are a fifth apart; A/B at one number are relative keys.
+func (k Key) camelot() (int, byte) {
+	tonic, letter := k.Tonic, byte('B')
+	if k.Mode == Minor {
+		tonic, letter = (k.Tonic+3)%12, 'A' // relative major
+	}
+	return (7*tonic+7)%12 + 1, letter
+}
+
+// harmonicDistance is the number of steps between two keys on the Camelot
+// wheel: 0 for the same key, 1 for a compatible neighbour (±1 number or
+// relative major/minor), more for clashing keys.
+func harmonicDistance(a, b Key) int {
+	na, la := a.camelot()
+	nb, lb := b.camelot()
+	d := (na - nb + 12) % 12
+	d = min(d, 12-d)
+	if la != lb {
+		d++
+	}
+	return d
+}
+
+// tempoDistance returns the tempo difference in percent, treating half
+// and double time as the same tempo.
+func tempoDistance(a, b float64) float64 {
+	d := math.Inf(1)
+	for _, r := range []float64{0.5, 1, 2} {
+		d = math.Min(d, math.Abs(math.Log(b*r/a)))
+	}
+	return (math.Exp(d) - 1) * 100
+}
+
+// transitionCost scores how smoothly next follows current: one point per
+// Camelot step plus one per 3% tempo difference. Unknown keys or tempos
+// cost a neutral 2 and 1 points.
+func transitionCost(current, next TrackInfo) float64 {
+	cost := 2.0
+	if current.Key.Known() && next.Key.Known() {
+		cost = float64(harmonicDistance(current.Key, next.Key))
+	}
+	if current.BPM > 0 && next.BPM > 0 {
+		cost += tempoDistance(current.BPM, next.BPM) / 3
+	} else {
+		cost++
+	}
+	return cost
+}
+
+// harmonicPick returns a pick function for trackQueue.pop that chooses the
+// lowest-cost candidate after current. Only the tracks ahead of the first
+// genre change are considered, so genre changes keep their place, and a
+// track passed over maxPassOver times plays next. A jingle at the
+// head always plays next.
+func harmonicPick(current TrackInfo) func([]*decodedTrack) int {
+	return func(items []*decodedTrack) int {
+		if items[0].info.Interstitial {
+			return 0
+		}
+		best, bestCost := 0, math.Inf(1)
+		for i, dt := range items {
+			if dt.info.Genre != items[0].info.Genre {
+				break
+			}
+			if dt.passedOver >= maxPassOver {
+				best = i
+				break
+			}
+			if c := transitionCost(current, dt.info); c < bestCost {
+				best, bestCost = i, c
+			}
+		}
+		for _, dt := range items[:best] {
+			dt.passedOver++
+		}
+		return best
+	}
+}
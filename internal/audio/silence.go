@@ -0,0 +1,86 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+// Leading/trailing silence detection.
+//
+// The analysis pass records the RMS level of every 10ms window. Trimming
+// is decided afterwards so the threshold can be applied to the level the
+// listener will actually hear, i.e. after loudness normalization.
+
+const (
+	levelHop   = SampleRate / 100       // 10ms level windows (per channel)
+	trimMargin = 50 * SampleRate / 1000 // keep 50ms around detected audio
+)
+
+// SilenceConfig controls leading/trailing silence trimming.
+type SilenceConfig struct {
+	ThresholdDB float64       // windows below this RMS level (dBFS) count as silence
+	MinDuration time.Duration // shorter silences are left alone; 0 disables trimming
+}
+
+// levelMeter records the RMS and peak level of consecutive 10ms windows.
+type levelMeter struct {
+	sum    float64
+	peak   float64
+	count  int
+	levels []float32 // linear RMS per window, 0-1
+	peaks  []float32 // max absolute sample per window
+}
+
+func (m *levelMeter) Write(samples []float32) {
+	for i := 0; i+Channels <= len(samples); i += Channels {
+		for ch := 0; ch < Channels; ch++ {
+			x := float64(samples[i+ch])
+			m.sum += x * x
+			m.peak = math.Max(m.peak, math.Abs(x))
+		}
+		m.count++
+		if m.count == levelHop {
+			m.levels = append(m.levels, float32(math.Sqrt(m.sum/float64(levelHop*Channels))))
+			m.peaks = append(m.peaks, float32(m.peak))
+			m.sum, m.peak, m.count = 0, 0, 0
+		}
+	}
+}
+
+// trimBounds returns the region [start, end) in samples per channel that
+// remains after dropping leading and trailing silence from a track of
+// length samples. gainDB is the gain that will be applied on playback.
+func trimBounds(levels []float32, length int, gainDB float64, cfg SilenceConfig) (start, end int) {
+	if cfg.MinDuration <= 0 {
+		return 0, length
+	}
+	threshold := float32(math.Pow(10, (cfg.ThresholdDB-gainDB)/20))
+
+	first, last := -1, -1
+	for i, l := range levels {
+		if l > threshold {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return 0, length // all silence: leave it for the glitch detector
+	}
+
+	minSamples := int(cfg.MinDuration.Seconds() * SampleRate)
+	start, end = 0, length
+	if lead := first * levelHop; lead >= minSamples {
+		start = max(0, lead-trimMargin)
+	}
+	if tail := (last + 1) * levelHop; length-tail >= minSamples {
+		end = min(length, tail+trimMargin)
+	}
+	return start, end
+}
+
+// samplesToDuration converts samples per channel to a duration.
+func samplesToDuration(n int) time.Duration {
+	return time.Duration(n) * time.Second / SampleRate
+}
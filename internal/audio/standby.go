@@ -0,0 +1,217 @@
+package audio
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Standby bed: what the pipeline plays when the decoded queue runs dry,
+// so listeners hear something instead of a stalled stream.
+
+// StandbyMode selects the standby bed.
+type StandbyMode string
+
+const (
+	StandbyOff    StandbyMode = "off"    // send nothing (players may stall)
+	StandbyLoop   StandbyMode = "loop"   // loop a local file
+	StandbyReplay StandbyMode = "replay" // replay the last track, ducked
+	StandbyNoise  StandbyMode = "noise"  // quiet comfort noise
+)
+
+// StandbyModes lists the supported standby modes.
+var StandbyModes = []StandbyMode{StandbyOff, StandbyLoop, StandbyReplay, StandbyNoise}
+
+// StandbyConfig configures the standby bed.
+type StandbyConfig struct {
+	Mode StandbyMode
+	File string // loop file for StandbyLoop
+}
+
+// ParseStandbyMode validates a standby mode name.
+func ParseStandbyMode(v string) (StandbyMode, error) {
+	for _, m := range StandbyModes {
+		if StandbyMode(v) == m {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown standby mode %q", v)
+}
+
+const (
+	standbyDuckDB = 9.0                                  // replayed tracks sit this far below normal level
+	standbyFade   = int(2 * time.Second / FrameDuration) // frames to fade the bed out under the next track
+	noiseLevel    = 0.01                                 // comfort noise RMS (~-40 dBFS)
+)
+
+// loopSource plays a source over and over, reopening it at the end.
+type loopSource struct {
+	open func() (frameSource, error)
+	cur  frameSource
+}
+
+func (l *loopSource) ReadFrame() ([]float32, bool) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if l.cur == nil {
+			src, err := l.open()
+			if err != nil {
+				log.Printf("Standby bed: %v", err)
+				return nil, false
+			}
+			l.cur = src
+		}
+		if frame, ok := l.cur.ReadFrame(); ok {
+			return frame, true
+		}
+		l.cur.Close()
+		l.cur = nil
+	}
+	return nil, false // empty source: don't spin reopening it
+}
+
+func (l *loopSource) Skip(n int) {}
+
+func (l *loopSource) Close() error {
+	if l.cur != nil {
+		return l.cur.Close()
+	}
+	return nil
+}
+
+// noiseSource generates soft brown-ish comfort noise: white noise through
+// a leaky integrator, which sounds like tape hiss rather than static.
+type noiseSource struct {
+	rng   *rand.Rand
+	state [Channels]float64
+}
+
+func newNoiseSource(seed int64) *noiseSource {
+	return &noiseSource{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (n *noiseSource) ReadFrame() ([]float32, bool) {
+	frame := make([]float32, FrameSamples)
+	for i := range frame {
+		ch := i % Channels
+		// Leak 0.98 with input scaled so the output RMS is noiseLevel.
+		n.state[ch] = 0.98*n.state[ch] + n.rng.NormFloat64()*noiseLevel*0.2
+		frame[i] = float32(n.state[ch])
+	}
+	return frame, true
+}
+
+func (n *noiseSource) Skip(int)     {}
+func (n *noiseSource) Close() error { return nil }
+
+// openBed creates the standby bed for the current configuration, falling
+// back to comfort noise when the loop file or last track can't be opened.
+func (p *Pipeline) openBed(ctx context.Context) frameSource {
+	cfg := p.Standby()
+	last := p.lastMusic()
+
+	var open func() (frameSource, error)
+	switch cfg.Mode {
+	case StandbyOff:
+		return nil
+	case StandbyLoop:
+		if cfg.File != "" {
+			open = func() (frameSource, error) { return OpenStream(ctx, cfg.File, 0) }
+		}
+	case StandbyReplay:
+		if last.Path != "" {
+			open = func() (frameSource, error) {
+				s, err := OpenStream(ctx, last.Path, last.Gain-standbyDuckDB)
+				if err != nil {
+					return nil, err
+				}
+				s.Skip(int(last.TrimStart.Seconds() * SampleRate))
+				return s, nil
+			}
+		}
+	}
+	if open != nil {
+		src, err := open()
+		if err == nil {
+			return &loopSource{open: open, cur: src}
+		}
+		log.Printf("Standby %s unavailable, using noise: %v", cfg.Mode, err)
+	}
+	return newNoiseSource(p.clock.clock.Now().UnixNano())
+}
+
+// waitTrack returns the next decoded track and the frame it starts from.
+// If none is ready it plays the standby bed until one is, then fades the
+// bed out under the start of the track. Returns false when the pipeline
+// is shutting down.
+func (p *Pipeline) waitTrack(ctx context.Context) (*decodedTrack, int, bool) {
+	last, _, _ := p.Status()
+	if d, closed := p.nextTrack(last); d != nil || closed {
+		p.clock.idle()
+		return d, 0, d != nil
+	}
+
+	bed := p.openBed(ctx)
+	if bed == nil {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil, 0, false
+			case <-p.queue.wait():
+			}
+			if d, closed := p.nextTrack(last); d != nil || closed {
+				p.clock.idle()
+				return d, 0, d != nil
+			}
+		}
+	}
+	defer bed.Close()
+
+	p.setStandby(true)
+	defer p.setStandby(false)
+	log.Printf("Queue empty, playing standby bed (%s)", p.Standby().Mode)
+
+	for {
+		if ctx.Err() != nil {
+			return nil, 0, false
+		}
+		if d, closed := p.nextTrack(last); d != nil {
+			log.Printf("Leaving standby: %s", d.info.ID)
+			return d, p.fadeOutBed(ctx, bed, d), true
+		} else if closed {
+			return nil, 0, false
+		}
+		frame, ok := bed.ReadFrame()
+		if !ok {
+			frame = make([]float32, FrameSamples) // bed failed: keep the stream alive
+		}
+		if !p.sendFrame(ctx, frame) && ctx.Err() != nil {
+			return nil, 0, false
+		}
+	}
+}
+
+// fadeOutBed crossfades from the bed into the start of dt and returns the
+// number of frames of dt consumed.
+func (p *Pipeline) fadeOutBed(ctx context.Context, bed frameSource, dt *decodedTrack) int {
+	n := min(standbyFade, dt.frames()/2)
+	fade := crossfade{curve: CurveEqualPower}
+	mixed := 0
+	for i := 0; i < n; i++ {
+		in, ok := dt.src.ReadFrame()
+		if !ok {
+			break
+		}
+		mixed++
+		out, ok := bed.ReadFrame()
+		if !ok {
+			out = make([]float32, FrameSamples)
+		}
+		if !p.sendFrame(ctx, fade.Mix(out, in, float64(i)/float64(n))) {
+			break // skipped or cancelled: play the track from here
+		}
+	}
+	dt.length -= mixed * FrameSize
+	return mixed
+}
@@ -0,0 +1,265 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Curve maps crossfade progress (0-1) to outgoing and incoming gains.
+type Curve string
+
+const (
+	CurveEqualPower  Curve = "equal-power" // constant power for uncorrelated material
+	CurveLinear      Curve = "linear"      // constant amplitude, dips ~3dB mid-fade
+	CurveLogarithmic Curve = "log"         // linear in dB, -60dB to 0dB
+	CurveSmoothstep  Curve = "smoothstep"  // original InfiniteRadio curve
+)
+
+// Curves lists the supported crossfade curves.
+var Curves = []Curve{CurveEqualPower, CurveLinear, CurveLogarithmic, CurveSmoothstep}
+
+// logFloorDB is where the logarithmic curve starts/ends before snapping to 0.
+const logFloorDB = -60.0
+
+// Gains returns the outgoing and incoming gains at progress t.
+func (c Curve) Gains(t float64) (out, in float64) {
+	t = math.Max(0, math.Min(1, t))
+	switch c {
+	case CurveLinear:
+		return 1 - t, t
+	case CurveLogarithmic:
+		return dbRamp(1 - t), dbRamp(t)
+	case CurveSmoothstep:
+		g := Smoothstep(t)
+		return 1 - g, g
+	default:
+		return math.Cos(t * math.Pi / 2), math.Sin(t * math.Pi / 2)
+	}
+}
+
+// dbRamp rises linearly in dB from logFloorDB at t=0 to 0dB at t=1.
+func dbRamp(t float64) float64 {
+	if t <= 0 {
+		return 0
+	}
+	return math.Pow(10, logFloorDB*(1-t)/20)
+}
+
+// Style selects how the outgoing track leaves during a transition.
+type Style string
+
+const (
+	StyleCrossfade    Style = "crossfade"     // plain blend using the curve
+	StyleHardCut      Style = "hard-cut"      // switch at the midpoint, no overlap
+	StyleEchoOut      Style = "echo-out"      // outgoing fades into a feedback delay
+	StyleLowPassSweep Style = "lowpass-sweep" // outgoing is low-passed as it fades
+)
+
+// Styles lists the supported transition styles.
+var Styles = []Style{StyleCrossfade, StyleHardCut, StyleEchoOut, StyleLowPassSweep}
+
+// Transition mixes the outgoing and incoming tracks for one crossfade.
+// Frames are float32 and may exceed full scale; the output limiter takes
+// care of overs. Implementations may keep state (filters, delay lines)
+// between frames, so create a fresh one per crossfade with TransitionSpec.New.
+type Transition interface {
+	// Mix blends one frame at the given progress (0 = all outgoing,
+	// 1 = all incoming). Both frames must have the same length.
+	Mix(outgoing, incoming []float32, progress float64) []float32
+}
+
+// TransitionSpec names a transition style and the curve it fades with.
+type TransitionSpec struct {
+	Style Style
+	Curve Curve
+}
+
+// DefaultTransition is an equal-power crossfade.
+var DefaultTransition = TransitionSpec{Style: StyleCrossfade, Curve: CurveEqualPower}
+
+// String formats the spec as "style:curve", the form ParseTransition accepts.
+func (s TransitionSpec) String() string {
+	return string(s.Style) + ":" + string(s.Curve)
+}
+
+// ParseTransition parses "style" or "style:curve". A missing curve
+// defaults to equal-power.
+func ParseTransition(v string) (TransitionSpec, error) {
+	styleStr, curveStr, _ := strings.Cut(strings.TrimSpace(v), ":")
+	spec := TransitionSpec{Style: Style(styleStr), Curve: CurveEqualPower}
+	if curveStr != "" {
+		spec.Curve = Curve(curveStr)
+	}
+	if !validStyle(spec.Style) {
+		return TransitionSpec{}, fmt.Errorf("unknown transition style %q", styleStr)
+	}
+	if !validCurve(spec.Curve) {
+		return TransitionSpec{}, fmt.Errorf("unknown crossfade curve %q", curveStr)
+	}
+	return spec, nil
+}
+
+// ParseTransitionRules parses per-genre rules of the form
+// "from>to=style[:curve],...". Either genre may be "*" to match any genre.
+func ParseTransitionRules(v string) (map[string]TransitionSpec, error) {
+	rules := make(map[string]TransitionSpec)
+	for _, rule := range strings.Split(v, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		pair, specStr, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("transition rule %q: missing '='", rule)
+		}
+		from, to, ok := strings.Cut(pair, ">")
+		if !ok {
+			return nil, fmt.Errorf("transition rule %q: want from>to", rule)
+		}
+		spec, err := ParseTransition(specStr)
+		if err != nil {
+			return nil, fmt.Errorf("transition rule %q: %w", rule, err)
+		}
+		rules[TransitionKey(strings.TrimSpace(from), strings.TrimSpace(to))] = spec
+	}
+	return rules, nil
+}
+
+// TransitionKey builds the rule key for a genre pair.
+func TransitionKey(from, to string) string {
+	return from + ">" + to
+}
+
+func validStyle(s Style) bool {
+	for _, v := range Styles {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func validCurve(c Curve) bool {
+	for _, v := range Curves {
+		if c == v {
+			return true
+		}
+	}
+	return false
+}
+
+// New creates a fresh transition for one crossfade.
+func (s TransitionSpec) New() Transition {
+	switch s.Style {
+	case StyleHardCut:
+		return hardCut{}
+	case StyleEchoOut:
+		return newEchoOut(s.Curve)
+	case StyleLowPassSweep:
+		return newLowPassSweep(s.Curve)
+	default:
+		return crossfade{curve: s.Curve}
+	}
+}
+
+type crossfade struct {
+	curve Curve
+}
+
+func (c crossfade) Mix(outgoing, incoming []float32, progress float64) []float32 {
+	gOut, gIn := c.curve.Gains(progress)
+	result := make([]float32, len(outgoing))
+	for i := range outgoing {
+		result[i] = float32(float64(outgoing[i])*gOut + float64(incoming[i])*gIn)
+	}
+	return result
+}
+
+type hardCut struct{}
+
+func (hardCut) Mix(outgoing, incoming []float32, progress float64) []float32 {
+	src := outgoing
+	if progress >= 0.5 {
+		src = incoming
+	}
+	result := make([]float32, len(src))
+	copy(result, src)
+	return result
+}
+
+// echoOut fades the dry outgoing signal over the first half of the
+// transition while feeding it into a feedback delay whose tail decays
+// under the incoming track.
+type echoOut struct {
+	curve    Curve
+	delay    []float64
+	pos      int
+	feedback float64
+}
+
+const echoDelay = 375 * SampleRate / 1000 // dotted eighth at 120 BPM
+
+func newEchoOut(curve Curve) *echoOut {
+	return &echoOut{
+		curve:    curve,
+		delay:    make([]float64, echoDelay*Channels),
+		feedback: 0.55,
+	}
+}
+
+func (e *echoOut) Mix(outgoing, incoming []float32, progress float64) []float32 {
+	dry, _ := e.curve.Gains(math.Min(1, progress*2))
+	_, gIn := e.curve.Gains(progress)
+	wet := 1 - progress
+
+	result := make([]float32, len(outgoing))
+	for i := range outgoing {
+		x := float64(outgoing[i]) * dry
+		echo := e.delay[e.pos]
+		e.delay[e.pos] = x + echo*e.feedback
+		e.pos = (e.pos + 1) % len(e.delay)
+		result[i] = float32(x + echo*wet*0.6 + float64(incoming[i])*gIn)
+	}
+	return result
+}
+
+// lowPassSweep closes a low-pass filter on the outgoing track from 20kHz
+// down to 200Hz while crossfading.
+type lowPassSweep struct {
+	curve   Curve
+	filters [Channels]biquad
+}
+
+func newLowPassSweep(curve Curve) *lowPassSweep {
+	return &lowPassSweep{curve: curve}
+}
+
+func (l *lowPassSweep) Mix(outgoing, incoming []float32, progress float64) []float32 {
+	cutoff := 20000 * math.Pow(200.0/20000, progress)
+	for ch := range l.filters {
+		l.filters[ch].setLowPass(cutoff, 0.707)
+	}
+	gOut, gIn := l.curve.Gains(progress)
+
+	result := make([]float32, len(outgoing))
+	for i := range outgoing {
+		out := l.filters[i%Channels].process(float64(outgoing[i]))
+		result[i] = float32(out*gOut + float64(incoming[i])*gIn)
+	}
+	return result
+}
+
+// setLowPass updates the coefficients to an RBJ low-pass at cutoff Hz,
+// keeping the filter state so sweeps stay click-free.
+func (f *biquad) setLowPass(cutoff, q float64) {
+	w := 2 * math.Pi * cutoff / SampleRate
+	alpha := math.Sin(w) / (2 * q)
+	cosw := math.Cos(w)
+	a0 := 1 + alpha
+	f.b0 = (1 - cosw) / 2 / a0
+	f.b1 = (1 - cosw) / a0
+	f.b2 = (1 - cosw) / 2 / a0
+	f.a1 = -2 * cosw / a0
+	f.a2 = (1 - alpha) / a0
+}
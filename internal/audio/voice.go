@@ -0,0 +1,283 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Voice-over: spoken announcements mixed over the music at track
+// boundaries, from a local file or a text-to-speech engine. An
+// announcement is decoded (and loudness-normalized) like a track, then
+// overlaid from the start of the next crossfade with the music ducked
+// under it by a sidechain on the voice level.
+
+const (
+	voiceDuckDB  = 12.0             // music level under an announcement
+	voiceTimeout = 30 * time.Second // max time to synthesize one announcement
+
+	// Sidechain: the music ducks while the overlay is above the gate and
+	// holds through short gaps (between words) before releasing.
+	overlayGate = 0.01 // -40 dBFS
+	overlayHold = 400 * time.Millisecond
+)
+
+var voiceDuck = math.Pow(10, -voiceDuckDB/20)
+
+// TTS turns text into speech.
+type TTS interface {
+	// Synthesize writes text as speech to the audio file out.
+	Synthesize(ctx context.Context, text, out string) error
+}
+
+// ExecTTS runs a local TTS engine such as piper or espeak. In Args,
+// "{text}" is replaced by the text and "{out}" by the output file. The
+// text is also written to stdin (piper reads it from there), and if no
+// argument contains {out}, stdout is saved as the output instead.
+type ExecTTS struct {
+	Args []string
+}
+
+// NewExecTTS parses a command line such as
+// "piper --model voice.onnx --output_file {out}" or
+// "espeak-ng -w {out} {text}". Arguments are split on spaces; no shell is
+// involved.
+func NewExecTTS(command string) (*ExecTTS, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty TTS command")
+	}
+	return &ExecTTS{Args: args}, nil
+}
+
+// Synthesize runs the command for text, writing to out.
+func (e *ExecTTS) Synthesize(ctx context.Context, text, out string) error {
+	r := strings.NewReplacer("{text}", text, "{out}", out)
+	args := make([]string, len(e.Args))
+	toFile := false
+	for i, a := range e.Args {
+		toFile = toFile || strings.Contains(a, "{out}")
+		args[i] = r.Replace(a)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if !toFile {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		cmd.Stdout = f
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("tts %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// announcement builds the text read between prev and a track in genre:
+// "That was <name>, up next some <genre>."
+func announcement(prev TrackInfo, genre string) string {
+	switch {
+	case prev.Name != "" && genre != "":
+		return fmt.Sprintf("That was %s, up next some %s.", prev.Name, genre)
+	case prev.Name != "":
+		return fmt.Sprintf("That was %s.", prev.Name)
+	case genre != "":
+		return fmt.Sprintf("Up next some %s.", genre)
+	}
+	return ""
+}
+
+// voiceOver holds the announcement settings.
+type voiceOver struct {
+	mu      sync.Mutex
+	tts     TTS
+	dir     string
+	enabled bool
+	seq     int
+}
+
+// SetTTS sets the engine announcements are synthesized with. A nil TTS
+// disables automatic announcements and text announcements.
+func (p *Pipeline) SetTTS(tts TTS) {
+	p.voice.mu.Lock()
+	p.voice.tts = tts
+	p.voice.mu.Unlock()
+}
+
+// SetVoiceDir sets the directory recorded announcements are picked from.
+func (p *Pipeline) SetVoiceDir(dir string) {
+	p.voice.mu.Lock()
+	p.voice.dir = dir
+	p.voice.mu.Unlock()
+}
+
+// VoiceFiles lists the recorded announcements in the voice directory.
+func (p *Pipeline) VoiceFiles() ([]string, error) {
+	p.voice.mu.Lock()
+	dir := p.voice.dir
+	p.voice.mu.Unlock()
+	return audioFiles(dir)
+}
+
+// SetVoiceOver turns automatic announcements at track boundaries on or off.
+func (p *Pipeline) SetVoiceOver(enabled bool) {
+	p.voice.mu.Lock()
+	p.voice.enabled = enabled
+	p.voice.mu.Unlock()
+	log.Printf("Voice-over: %v", enabled)
+}
+
+// VoiceOver reports whether automatic announcements are on.
+func (p *Pipeline) VoiceOver() bool {
+	p.voice.mu.Lock()
+	defer p.voice.mu.Unlock()
+	return p.voice.enabled
+}
+
+// Announce synthesizes text and queues it for the next track boundary.
+// Blocks for the synthesis and analysis.
+func (p *Pipeline) Announce(text string) error {
+	p.voice.mu.Lock()
+	tts := p.voice.tts
+	p.voice.mu.Unlock()
+	if tts == nil {
+		return fmt.Errorf("no TTS engine configured")
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("empty announcement")
+	}
+	return p.prepareVoice(context.Background(), tts, text)
+}
+
+// AnnounceFile queues a recorded announcement from the voice directory for
+// the next track boundary. Blocks for its analysis.
+func (p *Pipeline) AnnounceFile(name string) error {
+	files, err := p.VoiceFiles()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(files, name) {
+		return fmt.Errorf("unknown voice file %q", name)
+	}
+	p.voice.mu.Lock()
+	info := TrackInfo{ID: p.nextVoiceID(), Path: filepath.Join(p.voice.dir, name), Name: name, Interstitial: true}
+	p.voice.mu.Unlock()
+	dt, err := p.decode(context.Background(), info) // the stream lives until it airs
+	if err != nil {
+		return err
+	}
+	p.queueVoice(dt)
+	return nil
+}
+
+// nextVoiceID returns a fresh announcement ID. Callers hold voice.mu.
+func (p *Pipeline) nextVoiceID() string {
+	p.voice.seq++
+	return fmt.Sprintf("voice-%d", p.voice.seq)
+}
+
+// scheduleVoice is called as each generated track starts. With voice-over
+// on, it prepares "that was <track>, up next <genre>" in the background to
+// air at the end of the track. The genre is the next generated track's in
+// the queue, or the current one's if the queue is empty.
+func (p *Pipeline) scheduleVoice(ctx context.Context, current TrackInfo) {
+	p.voice.mu.Lock()
+	tts, enabled := p.voice.tts, p.voice.enabled
+	p.voice.mu.Unlock()
+	if !enabled || tts == nil {
+		return
+	}
+	genre := current.Genre
+	for _, q := range p.Queue() {
+		if !q.Track.Interstitial {
+			genre = q.Track.Genre
+			break
+		}
+	}
+	text := announcement(current, genre)
+	go func() {
+		if err := p.prepareVoice(ctx, tts, text); err != nil {
+			log.Printf("Voice-over: %v", err)
+		}
+	}()
+}
+
+// prepareVoice synthesizes text to a temporary file, decodes it and queues
+// it. The file is removed once the announcement has played; ctx bounds the
+// playback stream, and synthesis gets at most voiceTimeout.
+func (p *Pipeline) prepareVoice(ctx context.Context, tts TTS, text string) error {
+	f, err := os.CreateTemp("", "voice-*.wav")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	sctx, cancel := context.WithTimeout(ctx, voiceTimeout)
+	err = tts.Synthesize(sctx, text, f.Name())
+	cancel()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	p.voice.mu.Lock()
+	info := TrackInfo{ID: p.nextVoiceID(), Path: f.Name(), Name: text, Interstitial: true}
+	p.voice.mu.Unlock()
+	dt, err := p.decode(ctx, info)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	dt.src = &removeOnClose{frameSource: dt.src, path: f.Name()}
+	p.queueVoice(dt)
+	return nil
+}
+
+// queueVoice makes dt the announcement for the next track boundary,
+// replacing one already waiting.
+func (p *Pipeline) queueVoice(dt *decodedTrack) {
+	dt.duck = voiceDuck
+	select {
+	case old := <-p.voiceCh:
+		old.src.Close()
+	default:
+	}
+	select {
+	case p.voiceCh <- dt:
+		log.Printf("Announcement ready: %q", dt.info.Name)
+	default:
+		dt.src.Close() // lost a race with another announcement
+	}
+}
+
+// removeOnClose deletes a temporary file when its stream is closed.
+type removeOnClose struct {
+	frameSource
+	path string
+}
+
+func (r *removeOnClose) Close() error {
+	err := r.frameSource.Close()
+	os.Remove(r.path)
+	return err
+}
+
+// framePeak returns the largest absolute sample in frame.
+func framePeak(frame []float32) float64 {
+	peak := 0.0
+	for _, v := range frame {
+		peak = math.Max(peak, math.Abs(float64(v)))
+	}
+	return peak
+}
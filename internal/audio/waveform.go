@@ -0,0 +1,106 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Waveform and timeline data for drawing a track in the UI. The waveform
+// is built from the 10ms windows the analysis pass already records, so it
+// costs no extra decoding.
+
+// waveformBuckets is the waveform resolution for a whole track.
+const waveformBuckets = 1000
+
+// Waveform is a downsampled picture of a whole source file, at the level
+// it airs (after loudness normalization), linear 0-1.
+type Waveform struct {
+	Duration time.Duration // source file length
+	Peaks    []float32     // max absolute sample per bucket
+	RMS      []float32     // RMS per bucket
+}
+
+// BucketDuration returns the length of the source covered by one bucket.
+func (w Waveform) BucketDuration() time.Duration {
+	if len(w.Peaks) == 0 {
+		return 0
+	}
+	return w.Duration / time.Duration(len(w.Peaks))
+}
+
+// waveform condenses per-window peaks and RMS levels into at most buckets
+// buckets, scaled by gainDB.
+func waveform(peaks, levels []float32, samples int, gainDB float64, buckets int) Waveform {
+	w := Waveform{Duration: samplesToDuration(samples)}
+	n := min(len(peaks), len(levels))
+	buckets = min(buckets, n)
+	if buckets == 0 {
+		return w
+	}
+	g := math.Pow(10, gainDB/20)
+	w.Peaks = make([]float32, buckets)
+	w.RMS = make([]float32, buckets)
+	for b := range buckets {
+		lo, hi := b*n/buckets, (b+1)*n/buckets
+		var peak, sum float64
+		for i := lo; i < hi; i++ {
+			peak = math.Max(peak, float64(peaks[i]))
+			sum += float64(levels[i]) * float64(levels[i])
+		}
+		w.Peaks[b] = float32(math.Min(1, peak*g))
+		w.RMS[b] = float32(math.Min(1, math.Sqrt(sum/float64(hi-lo))*g))
+	}
+	return w
+}
+
+// Region is a span of a track's source file.
+type Region struct {
+	Start, End time.Duration
+}
+
+// TrackTimeline lays out a track on its source file: the waveform, the
+// region that airs after silence trimming (and beat alignment), and where
+// it crossfades with its neighbours.
+type TrackTimeline struct {
+	Track        TrackInfo
+	Aired        Region
+	CrossfadeIn  Region // overlaps the previous track
+	CrossfadeOut Region // overlaps the next track
+	Estimated    bool   // not on air yet: crossfades assume the current crossfade length
+}
+
+// Timeline returns the timeline of the track with the given ID, which may
+// be on air, queued (once decoded) or in the history.
+func (p *Pipeline) Timeline(id string) (TrackTimeline, error) {
+	track, _, _ := p.Status()
+	if track.ID == id {
+		return timeline(track, track.FadeIn, track.FadeOut, false), nil
+	}
+	for _, q := range p.Queue() {
+		if q.Track.ID != id {
+			continue
+		}
+		if !q.Decoded {
+			return TrackTimeline{}, fmt.Errorf("track %q not decoded yet", id)
+		}
+		cf := min(p.CrossfadeDuration(), (q.Track.TrimEnd-q.Track.TrimStart)/2)
+		return timeline(q.Track, cf, cf, true), nil
+	}
+	for _, e := range p.History() {
+		if e.Track.ID == id {
+			return timeline(e.Track, e.Track.FadeIn, e.Track.FadeOut, false), nil
+		}
+	}
+	return TrackTimeline{}, fmt.Errorf("track %q not found", id)
+}
+
+func timeline(t TrackInfo, fadeIn, fadeOut time.Duration, estimated bool) TrackTimeline {
+	return TrackTimeline{
+		Track:        t,
+		Aired:        Region{t.TrimStart, t.TrimEnd},
+		CrossfadeIn:  Region{t.TrimStart, t.TrimStart + fadeIn},
+		CrossfadeOut: Region{t.TrimEnd - fadeOut, t.TrimEnd},
+		Estimated:    estimated,
+	}
+}
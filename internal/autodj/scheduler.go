@@ -218,11 +218,17 @@ func (s *Scheduler) Run(ctx context.Context) {
 			listeners = s.listenerCountFn()
 		}
 
-		// Idle mode: skip generation if nobody's listening and we have a track ready
-		if listeners == 0 && s.pipeline.QueueSize() >= 1 {
+		// Idle mode: skip generation if nobody's listening (or the station
+		// is paused) and we have a track ready
+		paused := s.pipeline.Paused()
+		if (listeners == 0 || paused) && s.pipeline.QueueSize() >= 1 {
 			s.mu.Lock()
 			if !s.idle {
-				log.Println("No listeners -- pausing generation")
+				if paused {
+					log.Println("Station paused -- pausing generation")
+				} else {
+					log.Println("No listeners -- pausing generation")
+				}
 				s.idle = true
 			}
 			s.mu.Unlock()
@@ -328,10 +334,11 @@ func (s *Scheduler) generateTrack(ctx context.Context) {
 	log.Printf("Track ready: %s [%s] (genre: %s)", trackName, taskID, genre)
 
 	s.pipeline.Enqueue(audio.TrackInfo{
-		ID:    taskID,
-		Genre: genre,
-		Path:  path,
-		Name:  trackName,
+		ID:      taskID,
+		Genre:   genre,
+		Path:    path,
+		Name:    trackName,
+		Caption: caption,
 	})
 }
 
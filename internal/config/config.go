@@ -25,10 +25,35 @@ type Config struct {
 	// Server
 	Port int
 
+	// HTTP MP3 stream
+	HTTPEncoder string // shared or per-listener
+	MP3Bitrate  int    // kbps
+
+	// HLS output
+	HLSCodec   string        // aac or mp3
+	HLSBitrate int           // kbps
+	HLSSegment time.Duration // target segment length
+	HLSWindow  int           // segments in the live playlist
+
+	// Icecast source client (optional)
+	IcecastURL      string // e.g. http://icecast:8000; empty disables
+	IcecastMount    string
+	IcecastUser     string
+	IcecastPassword string
+	IcecastProtocol string // put or source
+	IcecastFormat   string // mp3, aac or opus
+	IcecastBitrate  int    // kbps, 0 for the format's default
+	IcecastPublic   bool
+
 	// Radio behavior
 	StartingGenre     string
 	TrackDuration     int           // seconds
 	CrossfadeDuration time.Duration // crossfade length
+	BeatSync          bool          // align crossfades to downbeats
+	HarmonicMix       bool          // reorder buffered tracks by key and tempo
+	TransitionStyle   string        // crossfade, hard-cut, echo-out, lowpass-sweep
+	TransitionCurve   string        // equal-power, linear, log, smoothstep
+	TransitionRules   string        // per-genre overrides: "from>to=style[:curve],..."
 	BufferAhead       int           // tracks to pre-generate
 	DwellMin          int           // min seconds per genre
 	DwellMax          int           // max seconds per genre
@@ -39,6 +64,37 @@ type Config struct {
 	Shift          float64 // timestep shift (1.0-5.0, base model only)
 	AudioFormat    string  // output format: flac, mp3, wav
 
+	// Loudness normalization (EBU R128)
+	TargetLUFS      float64 // integrated loudness target (broadcast: -23, streaming: -14)
+	TruePeakCeiling float64 // max true peak after normalization (dBTP)
+
+	// Silence trimming on generated tracks
+	SilenceThreshold   float64       // dBFS; quieter 10ms windows count as silence
+	SilenceMinDuration time.Duration // shorter leading/trailing silences are kept; 0 disables
+
+	// Dead-air protection when the decoded queue runs dry
+	StandbyMode string // off, loop, replay, noise
+	StandbyFile string // loop file for the "loop" mode
+
+	// Dead-air detection on the live output
+	DeadAirThreshold float64       // dBFS; quieter output counts as dead air
+	DeadAirTimeout   time.Duration // skip after this much dead air; 0 disables
+
+	// Ambient overlay loops, chosen through the API
+	AmbientDir string
+
+	// Station IDs and jingles between tracks
+	JingleDir   string // directory of jingle files; empty disables jingles
+	JingleRules string // per-genre schedule: "genre=every[:mode],..."
+
+	// DJ voice-over at track boundaries
+	VoiceOver  bool   // announce "that was ..., up next ..." automatically
+	TTSCommand string // e.g. "piper --model voice.onnx --output_file {out}"
+	VoiceDir   string // recorded announcements, played through the API
+
+	// Runtime settings persisted across restarts (master gain, mute)
+	StateFile string
+
 	// Ollama (optional, for LLM-powered captions)
 	OllamaURL   string // e.g. http://localhost:11434
 	OllamaModel string // e.g. qwen3:32b
@@ -53,9 +109,31 @@ func Load() Config {
 
 		Port: envInt("RADIO_PORT", 8080),
 
+		HTTPEncoder: envStr("RADIO_HTTP_ENCODER", "shared"),
+		MP3Bitrate:  envInt("RADIO_MP3_BITRATE", 192),
+
+		HLSCodec:   envStr("RADIO_HLS_CODEC", "aac"),
+		HLSBitrate: envInt("RADIO_HLS_BITRATE", 128),
+		HLSSegment: time.Duration(envFloat("RADIO_HLS_SEGMENT", 6) * float64(time.Second)),
+		HLSWindow:  envInt("RADIO_HLS_WINDOW", 6),
+
+		IcecastURL:      envStr("RADIO_ICECAST_URL", ""),
+		IcecastMount:    envStr("RADIO_ICECAST_MOUNT", "/infinara"),
+		IcecastUser:     envStr("RADIO_ICECAST_USER", "source"),
+		IcecastPassword: envStr("RADIO_ICECAST_PASSWORD", ""),
+		IcecastProtocol: envStr("RADIO_ICECAST_PROTOCOL", "put"),
+		IcecastFormat:   envStr("RADIO_ICECAST_FORMAT", "mp3"),
+		IcecastBitrate:  envInt("RADIO_ICECAST_BITRATE", 0),
+		IcecastPublic:   envBool("RADIO_ICECAST_PUBLIC", false),
+
 		StartingGenre:     envStr("RADIO_GENRE", "lofi hip hop"),
 		TrackDuration:     envInt("RADIO_TRACK_DURATION", 90),
 		CrossfadeDuration: time.Duration(envInt("RADIO_CROSSFADE_DURATION", 18)) * time.Second,
+		BeatSync:          envBool("RADIO_BEAT_SYNC", true),
+		HarmonicMix:       envBool("RADIO_HARMONIC_MIX", false),
+		TransitionStyle:   envStr("RADIO_TRANSITION_STYLE", "crossfade"),
+		TransitionCurve:   envStr("RADIO_TRANSITION_CURVE", "equal-power"),
+		TransitionRules:   envStr("RADIO_TRANSITION_RULES", ""),
 		BufferAhead:       envInt("RADIO_BUFFER_AHEAD", 3),
 		DwellMin:          envInt("RADIO_DWELL_MIN", 300),
 		DwellMax:          envInt("RADIO_DWELL_MAX", 900),
@@ -64,6 +142,29 @@ func Load() Config {
 		Shift:             envFloat("RADIO_SHIFT", 3.0),
 		AudioFormat:       envStr("RADIO_AUDIO_FORMAT", "flac"),
 
+		TargetLUFS:      envFloat("RADIO_TARGET_LUFS", -14),
+		TruePeakCeiling: envFloat("RADIO_TRUE_PEAK", -1),
+
+		SilenceThreshold:   envFloat("RADIO_SILENCE_THRESHOLD", -50),
+		SilenceMinDuration: time.Duration(envFloat("RADIO_SILENCE_MIN_DURATION", 0.3) * float64(time.Second)),
+
+		StandbyMode: envStr("RADIO_STANDBY", "replay"),
+		StandbyFile: envStr("RADIO_STANDBY_FILE", ""),
+
+		DeadAirThreshold: envFloat("RADIO_DEADAIR_THRESHOLD", -60),
+		DeadAirTimeout:   time.Duration(envFloat("RADIO_DEADAIR_TIMEOUT", 10) * float64(time.Second)),
+
+		AmbientDir: envStr("RADIO_AMBIENT_DIR", ""),
+
+		JingleDir:   envStr("RADIO_JINGLE_DIR", ""),
+		JingleRules: envStr("RADIO_JINGLE_RULES", "*=4"),
+
+		VoiceOver:  envBool("RADIO_VOICE_OVER", false),
+		TTSCommand: envStr("RADIO_TTS_COMMAND", ""),
+		VoiceDir:   envStr("RADIO_VOICE_DIR", ""),
+
+		StateFile: envStr("RADIO_STATE_FILE", "state.json"),
+
 		OllamaURL:   envStr("OLLAMA_URL", ""),
 		OllamaModel: envStr("OLLAMA_MODEL", "qwen3:32b"),
 	}
@@ -76,6 +177,15 @@ func envStr(key, fallback string) string {
 	return fallback
 }
 
+func envBool(key string, fallback bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
 func envInt(key string, fallback int) int {
 	if v := os.Getenv(key); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
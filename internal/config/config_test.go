@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -14,6 +15,19 @@ func TestLoadDefaults(t *testing.T) {
 		"RADIO_CROSSFADE_DURATION", "RADIO_BUFFER_AHEAD",
 		"RADIO_DWELL_MIN", "RADIO_DWELL_MAX", "RADIO_INFERENCE_STEPS",
 		"RADIO_GUIDANCE_SCALE", "RADIO_SHIFT", "RADIO_AUDIO_FORMAT",
+		"RADIO_TARGET_LUFS", "RADIO_TRUE_PEAK", "RADIO_BEAT_SYNC",
+		"RADIO_HARMONIC_MIX",
+		"RADIO_TRANSITION_STYLE", "RADIO_TRANSITION_CURVE", "RADIO_TRANSITION_RULES",
+		"RADIO_SILENCE_THRESHOLD", "RADIO_SILENCE_MIN_DURATION",
+		"RADIO_STANDBY", "RADIO_STANDBY_FILE",
+		"RADIO_DEADAIR_THRESHOLD", "RADIO_DEADAIR_TIMEOUT",
+		"RADIO_AMBIENT_DIR", "RADIO_STATE_FILE",
+		"RADIO_JINGLE_DIR", "RADIO_JINGLE_RULES",
+		"RADIO_VOICE_OVER", "RADIO_TTS_COMMAND", "RADIO_VOICE_DIR",
+		"RADIO_HTTP_ENCODER", "RADIO_MP3_BITRATE",
+		"RADIO_HLS_CODEC", "RADIO_HLS_BITRATE", "RADIO_HLS_SEGMENT", "RADIO_HLS_WINDOW",
+		"RADIO_ICECAST_URL", "RADIO_ICECAST_MOUNT", "RADIO_ICECAST_USER", "RADIO_ICECAST_PASSWORD",
+		"RADIO_ICECAST_PROTOCOL", "RADIO_ICECAST_FORMAT", "RADIO_ICECAST_BITRATE", "RADIO_ICECAST_PUBLIC",
 	}
 	for _, k := range envVars {
 		os.Unsetenv(k)
@@ -63,6 +77,53 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.AudioFormat != "flac" {
 		t.Errorf("AudioFormat = %q, want 'flac'", cfg.AudioFormat)
 	}
+	if !cfg.BeatSync {
+		t.Error("BeatSync = false, want true")
+	}
+	if cfg.HarmonicMix {
+		t.Error("HarmonicMix = true, want false")
+	}
+	if cfg.TransitionStyle != "crossfade" || cfg.TransitionCurve != "equal-power" {
+		t.Errorf("Transition = %s:%s, want crossfade:equal-power", cfg.TransitionStyle, cfg.TransitionCurve)
+	}
+	if cfg.SilenceThreshold != -50 || cfg.SilenceMinDuration != 300*time.Millisecond {
+		t.Errorf("Silence trim = %v dBFS / %v, want -50 / 300ms", cfg.SilenceThreshold, cfg.SilenceMinDuration)
+	}
+	if cfg.StandbyMode != "replay" || cfg.StandbyFile != "" {
+		t.Errorf("Standby = %q %q, want replay with no file", cfg.StandbyMode, cfg.StandbyFile)
+	}
+	if cfg.DeadAirThreshold != -60 || cfg.DeadAirTimeout != 10*time.Second {
+		t.Errorf("Dead air = %v dBFS / %v, want -60 / 10s", cfg.DeadAirThreshold, cfg.DeadAirTimeout)
+	}
+	if cfg.AmbientDir != "" {
+		t.Errorf("AmbientDir = %q, want empty", cfg.AmbientDir)
+	}
+	if cfg.JingleDir != "" || cfg.JingleRules != "*=4" {
+		t.Errorf("Jingles = %q / %q, want disabled with rules *=4", cfg.JingleDir, cfg.JingleRules)
+	}
+	if cfg.VoiceOver || cfg.TTSCommand != "" || cfg.VoiceDir != "" {
+		t.Errorf("Voice-over = %v / %q / %q, want off with no TTS or files", cfg.VoiceOver, cfg.TTSCommand, cfg.VoiceDir)
+	}
+	if cfg.HTTPEncoder != "shared" || cfg.MP3Bitrate != 192 {
+		t.Errorf("HTTP encoder = %q at %dk, want shared at 192k", cfg.HTTPEncoder, cfg.MP3Bitrate)
+	}
+	if cfg.HLSCodec != "aac" || cfg.HLSBitrate != 128 || cfg.HLSSegment != 6*time.Second || cfg.HLSWindow != 6 {
+		t.Errorf("HLS = %q %dk, %v x %d, want aac 128k, 6s x 6", cfg.HLSCodec, cfg.HLSBitrate, cfg.HLSSegment, cfg.HLSWindow)
+	}
+	if cfg.IcecastURL != "" || cfg.IcecastMount != "/infinara" || cfg.IcecastUser != "source" ||
+		cfg.IcecastProtocol != "put" || cfg.IcecastFormat != "mp3" || cfg.IcecastBitrate != 0 || cfg.IcecastPublic {
+		t.Errorf("Icecast = %q%s as %q via %s, %s %dk, public %v; want disabled, /infinara as source via put, mp3",
+			cfg.IcecastURL, cfg.IcecastMount, cfg.IcecastUser, cfg.IcecastProtocol, cfg.IcecastFormat, cfg.IcecastBitrate, cfg.IcecastPublic)
+	}
+	if cfg.StateFile != "state.json" {
+		t.Errorf("StateFile = %q, want state.json", cfg.StateFile)
+	}
+	if cfg.TargetLUFS != -14 {
+		t.Errorf("TargetLUFS = %f, want -14", cfg.TargetLUFS)
+	}
+	if cfg.TruePeakCeiling != -1 {
+		t.Errorf("TruePeakCeiling = %f, want -1", cfg.TruePeakCeiling)
+	}
 }
 
 func TestLoadFromEnv(t *testing.T) {
@@ -143,3 +204,36 @@ func TestEnvStrEmpty(t *testing.T) {
 		t.Errorf("Unset env should use fallback: got %q", cfg.ACEStepAPIURL)
 	}
 }
+
+func TestEnvBoolInvalidFallsBack(t *testing.T) {
+	t.Setenv("RADIO_BEAT_SYNC", "maybe")
+	if cfg := Load(); !cfg.BeatSync {
+		t.Error("Invalid bool env should fallback to default true")
+	}
+	t.Setenv("RADIO_BEAT_SYNC", "false")
+	if cfg := Load(); cfg.BeatSync {
+		t.Error("RADIO_BEAT_SYNC=false should disable beat sync")
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if s, err := LoadState(path); err != nil || s != (State{}) {
+		t.Fatalf("Missing state file = %+v, %v; want zero state", s, err)
+	}
+	want := State{MasterGain: -6.5, Muted: true}
+	if err := SaveState(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := LoadState(path); err != nil || got != want {
+		t.Errorf("LoadState = %+v, %v; want %+v", got, err, want)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("State dir has %d files, want only the state file", len(entries))
+	}
+
+	os.WriteFile(path, []byte("{"), 0o644)
+	if _, err := LoadState(path); err == nil {
+		t.Error("Corrupt state file loaded without error")
+	}
+}
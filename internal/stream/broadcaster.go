@@ -3,6 +3,8 @@ package stream
 import (
 	"context"
 	"sync"
+
+	"github.com/satindergrewal/infinara/internal/audio"
 )
 
 // Broadcaster fans out PCM frames from one source to N listeners.
@@ -13,8 +15,9 @@ type Broadcaster struct {
 
 // Listener receives PCM frames from the broadcaster.
 type Listener struct {
-	C    chan []int16   // buffered channel of 20ms PCM frames
+	C    chan []int16 // buffered channel of 20ms PCM frames
 	done chan struct{}
+	tap  bool // internal consumer, not counted as a listener
 }
 
 // NewBroadcaster creates a new broadcaster.
@@ -26,9 +29,20 @@ func NewBroadcaster() *Broadcaster {
 
 // Subscribe registers a new listener. Returns a Listener that receives frames.
 func (b *Broadcaster) Subscribe() *Listener {
+	return b.subscribe(false)
+}
+
+// Tap registers a listener for an internal consumer, such as a shared
+// encoder, that is not counted by ListenerCount.
+func (b *Broadcaster) Tap() *Listener {
+	return b.subscribe(true)
+}
+
+func (b *Broadcaster) subscribe(tap bool) *Listener {
 	l := &Listener{
 		C:    make(chan []int16, 150), // ~3 seconds of buffer at 20ms/frame
 		done: make(chan struct{}),
+		tap:  tap,
 	}
 	b.mu.Lock()
 	b.listeners[l] = struct{}{}
@@ -44,24 +58,32 @@ func (b *Broadcaster) Unsubscribe(l *Listener) {
 	close(l.done)
 }
 
-// ListenerCount returns the number of active listeners.
+// ListenerCount returns the number of active listeners, not counting taps.
 func (b *Broadcaster) ListenerCount() int {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-	return len(b.listeners)
+	n := 0
+	for l := range b.listeners {
+		if !l.tap {
+			n++
+		}
+	}
+	return n
 }
 
-// Run reads frames from source and fans out to all listeners.
-// Slow listeners get frames dropped rather than blocking the broadcast.
-func (b *Broadcaster) Run(ctx context.Context, source <-chan []int16) {
+// Run reads float32 frames from source, converts each to int16 once, and
+// fans out to all listeners. Slow listeners get frames dropped rather than
+// blocking the broadcast.
+func (b *Broadcaster) Run(ctx context.Context, source <-chan []float32) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case frame, ok := <-source:
+		case f, ok := <-source:
 			if !ok {
 				return
 			}
+			frame := audio.FloatToInt16(f)
 			b.mu.RLock()
 			for l := range b.listeners {
 				select {
@@ -1,10 +1,22 @@
 package stream
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
+
+	"github.com/satindergrewal/infinara/internal/audio"
 )
 
 func TestNewBroadcaster(t *testing.T) {
@@ -46,13 +58,13 @@ func TestBroadcastDelivers(t *testing.T) {
 	l := b.Subscribe()
 
 	ctx, cancel := context.WithCancel(context.Background())
-	source := make(chan []int16, 10)
+	source := make(chan []float32, 10)
 
 	go b.Run(ctx, source)
 
 	// Send a frame
 	frame := []int16{100, 200, 300, 400}
-	source <- frame
+	source <- audio.Int16ToFloat(frame)
 
 	// Listener should receive it
 	select {
@@ -81,12 +93,11 @@ func TestBroadcastMultipleListeners(t *testing.T) {
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	source := make(chan []int16, 10)
+	source := make(chan []float32, 10)
 
 	go b.Run(ctx, source)
 
-	frame := []int16{42, -42}
-	source <- frame
+	source <- audio.Int16ToFloat([]int16{42, -42})
 
 	// All listeners should get the frame
 	for i, l := range listeners {
@@ -112,13 +123,13 @@ func TestBroadcastDropsSlowListener(t *testing.T) {
 	fast := b.Subscribe()
 
 	ctx, cancel := context.WithCancel(context.Background())
-	source := make(chan []int16, 200)
+	source := make(chan []float32, 200)
 
 	go b.Run(ctx, source)
 
 	// Fill the slow listener's buffer (150 capacity) without reading
 	for i := 0; i < 200; i++ {
-		source <- []int16{int16(i)}
+		source <- audio.Int16ToFloat([]int16{int16(i)})
 	}
 
 	// Give broadcaster time to process
@@ -163,7 +174,7 @@ countDone:
 func TestBroadcastStopsOnContextCancel(t *testing.T) {
 	b := NewBroadcaster()
 	ctx, cancel := context.WithCancel(context.Background())
-	source := make(chan []int16, 10)
+	source := make(chan []float32, 10)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -191,7 +202,7 @@ func TestBroadcastStopsOnContextCancel(t *testing.T) {
 func TestBroadcastStopsOnSourceClose(t *testing.T) {
 	b := NewBroadcaster()
 	ctx := context.Background()
-	source := make(chan []int16, 10)
+	source := make(chan []float32, 10)
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -230,3 +241,722 @@ func TestListenerDoneChannel(t *testing.T) {
 		t.Error("Listener done channel not closed after unsubscribe")
 	}
 }
+
+func TestTapNotCounted(t *testing.T) {
+	b := NewBroadcaster()
+	tap := b.Tap()
+	l := b.Subscribe()
+	if b.ListenerCount() != 1 {
+		t.Errorf("ListenerCount = %d, want 1 (taps not counted)", b.ListenerCount())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := make(chan []float32, 1)
+	go b.Run(ctx, source)
+	source <- []float32{0.5, -0.5}
+	for _, c := range []chan []int16{tap.C, l.C} {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for frame")
+		}
+	}
+	b.Unsubscribe(tap)
+	b.Unsubscribe(l)
+}
+
+// mp3Header builds an MPEG-1 Layer III frame header at 48kHz.
+func mp3Header(bitrateIdx byte, padding bool) []byte {
+	h := []byte{0xFF, 0xFB, bitrateIdx<<4 | 1<<2, 0xC4}
+	if padding {
+		h[2] |= 2
+	}
+	return h
+}
+
+func TestMP3FrameLen(t *testing.T) {
+	tests := []struct {
+		name string
+		h    []byte
+		want int
+	}{
+		{"192k", mp3Header(11, false), 576},
+		{"128k padded", mp3Header(9, true), 385},
+		{"MPEG-2 64k 24kHz", []byte{0xFF, 0xF3, 0x84, 0xC4}, 192},
+		{"free bitrate", mp3Header(0, false), 0},
+		{"layer II", []byte{0xFF, 0xFD, 0xB4, 0xC4}, 0},
+		{"no sync", []byte{0x49, 0x44, 0x33, 0x04}, 0},
+	}
+	for _, tt := range tests {
+		if got := mp3FrameLen(tt.h); got != tt.want {
+			t.Errorf("%s: mp3FrameLen = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if !ValidMP3Bitrate(192) || ValidMP3Bitrate(0) || ValidMP3Bitrate(100) {
+		t.Error("ValidMP3Bitrate accepts the wrong bitrates")
+	}
+}
+
+func TestReadMP3FrameResyncs(t *testing.T) {
+	frame := func(b byte) []byte {
+		f := make([]byte, 576)
+		copy(f, mp3Header(11, false))
+		f[4] = b
+		return f
+	}
+	var data []byte
+	data = append(data, "ID3 junk"...)
+	data = append(data, frame(1)...)
+	data = append(data, frame(2)...)
+	data = append(data, 0xFF, 0xFB) // truncated header
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	for _, want := range []byte{1, 2} {
+		f, err := readMP3Frame(r)
+		if err != nil || len(f) != 576 || f[4] != want {
+			t.Fatalf("Frame %d: len %d, err %v", want, len(f), err)
+		}
+	}
+	if _, err := readMP3Frame(r); err == nil {
+		t.Error("Truncated frame read without error")
+	}
+}
+
+func TestFrameRing(t *testing.T) {
+	r := newFrameRing(8, 3)
+	if c := r.join(); c != 0 {
+		t.Errorf("Join on empty ring = %d, want 0", c)
+	}
+	for i := 0; i < 5; i++ {
+		r.push([]byte{byte(i)})
+	}
+
+	// A new reader starts the burst behind the live edge, on a frame.
+	cursor := r.join()
+	frames, next, skipped, wake, ok := r.read(cursor)
+	if !ok || skipped != 0 || next != 5 || len(frames) != 3 || frames[0][0] != 2 {
+		t.Fatalf("Burst = %v (next %d, skipped %d), want frames 2-4", frames, next, skipped)
+	}
+	select {
+	case <-wake:
+		t.Fatal("Woken with no new frame")
+	default:
+	}
+	r.push([]byte{5})
+	select {
+	case <-wake:
+	default:
+		t.Fatal("Not woken by a new frame")
+	}
+
+	// A reader that falls out of the ring skips ahead to the burst.
+	for i := 6; i < 20; i++ {
+		r.push([]byte{byte(i)})
+	}
+	frames, next, skipped, _, _ = r.read(next)
+	if skipped != 12 || len(frames) != 3 || frames[0][0] != 17 || next != 20 {
+		t.Errorf("Lagging read: %d frames from %v, skipped %d; want 3 from 17, skipped 12", len(frames), frames[0], skipped)
+	}
+
+	// Closed: remaining frames are still delivered, then reads stop.
+	r.push([]byte{20})
+	r.close()
+	if frames, next, _, _, ok = r.read(next); !ok || len(frames) != 1 {
+		t.Errorf("Read after close = %d frames, ok %v; want the last frame", len(frames), ok)
+	}
+	if _, _, _, _, ok = r.read(next); ok {
+		t.Error("Drained closed ring still ok")
+	}
+}
+
+func TestADTSFrameLen(t *testing.T) {
+	h := []byte{0xFF, 0xF1, 0x4C, 0x80, 0x2E, 0x7F, 0xFC} // 48kHz stereo, 371 bytes
+	if n := adtsFrameLen(h); n != 371 {
+		t.Errorf("adtsFrameLen = %d, want 371", n)
+	}
+	if n := adtsFrameLen([]byte{0xFF, 0xFB, 0x90, 0x64, 0, 0, 0}); n != 0 {
+		t.Errorf("adtsFrameLen(MP3 header) = %d, want 0", n)
+	}
+}
+
+func TestID3Tag(t *testing.T) {
+	tag := id3Tag(10*time.Second, NowPlaying{Title: "Night Drive", Genre: "synthwave"})
+	if string(tag[:3]) != "ID3" || tag[3] != 4 {
+		t.Fatalf("Tag header = %q, want ID3v2.4", tag[:4])
+	}
+	size := int(tag[6])<<21 | int(tag[7])<<14 | int(tag[8])<<7 | int(tag[9])
+	if size != len(tag)-10 {
+		t.Errorf("Tag size = %d, want %d", size, len(tag)-10)
+	}
+	priv := "PRIV\x00\x00\x00\x35\x00\x00com.apple.streaming.transportStreamTimestamp\x00\x00\x00\x00\x00\x00\x0d\xbb\xa0"
+	if !bytes.HasPrefix(tag[10:], []byte(priv)) {
+		t.Errorf("PRIV frame = %q, want timestamp 900000", tag[10:10+len(priv)])
+	}
+	for _, want := range []string{"TIT2\x00\x00\x00\x0c\x00\x00\x03Night Drive", "TCON\x00\x00\x00\x0a\x00\x00\x03synthwave"} {
+		if !bytes.Contains(tag, []byte(want)) {
+			t.Errorf("Tag missing frame %q", want)
+		}
+	}
+	if got := syncsafe(300); !bytes.Equal(got, []byte{0, 0, 2, 0x2C}) {
+		t.Errorf("syncsafe(300) = %v, want [0 0 2 44]", got)
+	}
+
+	// Past 28h pts*90000 overflows int64; the timestamp still wraps at 33 bits.
+	late := id3Tag(30*time.Hour+10*time.Second, NowPlaying{})
+	if ts, want := binary.BigEndian.Uint64(late[10+len(priv)-8:]), uint64((30*3600+10)*90000%(1<<33)); ts != want {
+		t.Errorf("Timestamp at 30h = %d, want %d", ts, want)
+	}
+}
+
+func TestHLSSegments(t *testing.T) {
+	// Stand in for the AAC encoder so no FFmpeg is needed.
+	encoders := NewEncoders(NewBroadcaster())
+	enc := encoders.get(aacCodec, 128)
+	ring := newFrameRing(1000, 100)
+	enc.ring, enc.cancel = ring, func() {}
+
+	var mu sync.Mutex
+	np := NowPlaying{Title: "Track A", Genre: "ambient"}
+	setTrack := func(title string) {
+		mu.Lock()
+		np.Title = title
+		mu.Unlock()
+	}
+	h, err := NewHLSHandler(encoders, HLSConfig{Codec: "aac", Bitrate: 128, Segment: time.Second, Window: 3},
+		func() NowPlaying { mu.Lock(); defer mu.Unlock(); return np })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := []byte{0xFF, 0xF1, 0x4C, 0x80, 0x01, 0x1F, 0xFC, 0xAA} // 8-byte ADTS frame
+	push := func(n int) {
+		h.mu.Lock()
+		want := h.pts + time.Duration(n)*aacFrameDuration
+		h.mu.Unlock()
+		for range n {
+			ring.push(frame)
+		}
+		for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+			h.mu.Lock()
+			done := h.pts >= want
+			h.mu.Unlock()
+			if done {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Segmenter did not consume frames")
+			}
+		}
+	}
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	h.touch("192.0.2.1:5000")
+	push(47) // one full 1s segment
+	setTrack("Track B")
+	push(10) // the track change cuts segment 0 and starts segment 1
+	setTrack("Track C")
+	push(1)
+
+	resp, err := http.Get(srv.URL + "/hls/live.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/vnd.apple.mpegurl" {
+		t.Errorf("Playlist Content-Type = %q", ct)
+	}
+	playlist := string(body)
+	for _, want := range []string{
+		"#EXT-X-TARGETDURATION:2\n", "#EXT-X-MEDIA-SEQUENCE:0\n",
+		"#EXTINF:1.003,Track A\n0.aac\n", "#EXTINF:0.213,Track B\n1.aac\n",
+	} {
+		if !strings.Contains(playlist, want) {
+			t.Errorf("Playlist missing %q:\n%s", want, playlist)
+		}
+	}
+	if n := strings.Count(playlist, "#EXT-X-PROGRAM-DATE-TIME:"); n != 2 {
+		t.Errorf("Playlist has %d PROGRAM-DATE-TIME tags, want 2", n)
+	}
+
+	resp, err = http.Get(srv.URL + "/hls/1.aac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.HasPrefix(seg, []byte("ID3")) || !bytes.Contains(seg, []byte("Track B")) || !bytes.HasSuffix(seg, frame) {
+		t.Errorf("Segment 1 is not an ID3 tag for Track B followed by frames")
+	}
+	if resp.Header.Get("Content-Type") != "audio/aac" {
+		t.Errorf("Segment Content-Type = %q, want audio/aac", resp.Header.Get("Content-Type"))
+	}
+	resp, err = http.Get(srv.URL + "/hls/99.aac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Missing segment status = %d, want 404", resp.StatusCode)
+	}
+
+	if n := h.ListenerCount(); n != 2 {
+		t.Errorf("ListenerCount = %d, want 2 (test client and 192.0.2.1)", n)
+	}
+	ring.close()
+}
+
+func TestHLSGap(t *testing.T) {
+	encoders := NewEncoders(NewBroadcaster())
+	enc := encoders.get(aacCodec, 128)
+	ring := newFrameRing(20, 5)
+	enc.ring, enc.cancel = ring, func() {}
+	np := NowPlaying{Title: "Track A"}
+
+	// stall holds the segmenter inside its first frame while the ring
+	// laps it.
+	var stall atomic.Bool
+	reading, release := make(chan struct{}), make(chan struct{})
+	h, err := NewHLSHandler(encoders, HLSConfig{Codec: "aac", Bitrate: 128, Segment: time.Second, Window: 3}, func() NowPlaying {
+		if stall.CompareAndSwap(true, false) {
+			reading <- struct{}{}
+			<-release
+		}
+		return np
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumed := func(n int) {
+		for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+			h.mu.Lock()
+			pts := h.pts
+			h.mu.Unlock()
+			if pts == time.Duration(n)*aacFrameDuration {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Stream time = %v, want %d frames", pts, n)
+			}
+		}
+	}
+
+	frame := []byte{0xFF, 0xF1, 0x4C, 0x80, 0x01, 0x1F, 0xFC, 0xAA}
+	stall.Store(true)
+	h.touch("192.0.2.1:5000")
+	ring.push(frame)
+	<-reading
+	for range 40 {
+		ring.push(frame)
+	}
+	close(release)
+	// The segmenter rejoins at the burst: frames 1-35 are lost.
+	consumed(41)
+	for range 47 {
+		ring.push(frame)
+	}
+	consumed(88)
+
+	playlist := string(h.playlist())
+	if !strings.Contains(playlist, "0.aac\n#EXT-X-DISCONTINUITY\n#EXT-X-PROGRAM-DATE-TIME:") {
+		t.Errorf("Playlist has no discontinuity after segment 0:\n%s", playlist)
+	}
+	if seg := h.segment(0); seg == nil || seg.duration != aacFrameDuration {
+		t.Errorf("Segment 0 = %+v, want it cut at the gap after one frame", seg)
+	}
+	if seg := h.segment(1); seg == nil || !bytes.HasPrefix(seg.data, id3Tag(36*aacFrameDuration, np)) {
+		t.Error("Segment 1 timestamp does not count the lost frames")
+	}
+	ring.close()
+}
+
+func TestICYWriter(t *testing.T) {
+	np := NowPlaying{Title: "Night Drive", Genre: "synthwave"}
+	var out bytes.Buffer
+	iw := &icyWriter{w: &out, interval: 10, remaining: 10, nowPlaying: func() NowPlaying { return np }}
+	iw.Write([]byte("0123456"))
+	iw.Write([]byte("789abcdefghij")) // crosses the first block
+	np.Title = "Slow Tide"
+	iw.Write([]byte("klmnopqrst"))
+
+	title1 := "StreamTitle='Night Drive - synthwave';"
+	title2 := "StreamTitle='Slow Tide - synthwave';"
+	want := "0123456789" + "\x03" + title1 + strings.Repeat("\x00", 48-len(title1)) +
+		"abcdefghij" + "\x00" + // unchanged: empty block
+		"klmnopqrst" + "\x03" + title2 + strings.Repeat("\x00", 48-len(title2))
+	if out.String() != want {
+		t.Errorf("Stream = %q\nwant     %q", out.String(), want)
+	}
+
+	// Quotes and semicolons would end the title early.
+	out.Reset()
+	np = NowPlaying{Title: "Don't Stop; Go"}
+	iw.Write([]byte("0123456789"))
+	if want := "StreamTitle='Don’t Stop, Go';"; !strings.Contains(out.String(), want) {
+		t.Errorf("Metadata = %q, want %q", out.String()[10:], want)
+	}
+
+	// A long title is cut to 255 blocks on a rune boundary.
+	out.Reset()
+	np = NowPlaying{Title: strings.Repeat("é", 3000)}
+	iw.Write([]byte("0123456789"))
+	block := out.Bytes()[10:]
+	if block[0] != 255 || len(block) != 1+255*16 || !utf8.Valid(bytes.TrimRight(block[1:], "\x00")) {
+		t.Errorf("Long title block has %d blocks, valid UTF-8 %v", block[0], utf8.Valid(bytes.TrimRight(block[1:], "\x00")))
+	}
+}
+
+func TestHTTPICYMetadata(t *testing.T) {
+	// Stand in for the MP3 encoder so no FFmpeg is needed.
+	encoders := NewEncoders(NewBroadcaster())
+	enc := encoders.get(mp3Codec, 192)
+	ring := newFrameRing(1000, 100)
+	enc.ring, enc.cancel = ring, func() {}
+	frame := append(mp3Header(11, false), make([]byte, 572)...) // 192k, 576 bytes
+	for range 30 {
+		ring.push(frame)
+	}
+	h := NewHTTPHandler(encoders, HTTPConfig{Bitrate: 192}, func() NowPlaying {
+		return NowPlaying{Title: "Night Drive", Genre: "synthwave"}
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Icy-MetaData", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if mi := resp.Header.Get("icy-metaint"); mi != "16000" {
+		t.Fatalf("icy-metaint = %q, want 16000", mi)
+	}
+	buf := make([]byte, icyMetaInt+1+48)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:4], frame[:4]) {
+		t.Errorf("Stream starts with % x, want an MP3 frame", buf[:4])
+	}
+	meta := buf[icyMetaInt:]
+	if meta[0] != 3 || !bytes.HasPrefix(meta[1:], []byte("StreamTitle='Night Drive - synthwave';")) {
+		t.Errorf("Metadata block = %q", meta)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string // format name, "" for none
+	}{
+		{"", "mp3"},
+		{"*/*", "mp3"},
+		{"audio/webm,audio/ogg,audio/wav,audio/*;q=0.9,application/ogg;q=0.7,video/*;q=0.6,*/*;q=0.5", "mp3"}, // Firefox <audio>
+		{"audio/flac", "flac"},
+		{"audio/ogg;q=0.5, audio/flac", "flac"},
+		{"audio/wav, audio/mpeg;q=0", "wav"},
+		{"audio/*, audio/mpeg;q=0", "aac"},
+		{"text/html", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if c := negotiate(tt.accept); c != nil {
+			got = c.name
+		}
+		if got != tt.want {
+			t.Errorf("negotiate(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+// oggPage builds an Ogg page with the given granule position and body.
+func oggPage(granule uint64, body []byte) []byte {
+	p := []byte("OggS\x00\x00")
+	p = binary.LittleEndian.AppendUint64(p, granule)
+	p = append(p, make([]byte, 12)...) // serial, sequence, CRC
+	var lacing []byte
+	n := len(body)
+	for ; n >= 255; n -= 255 {
+		lacing = append(lacing, 255)
+	}
+	p = append(p, byte(len(lacing)+1))
+	p = append(p, append(lacing, byte(n))...)
+	return append(p, body...)
+}
+
+func TestReadOggPage(t *testing.T) {
+	head := oggPage(0, []byte("OpusHead"))
+	music := oggPage(4800, bytes.Repeat([]byte{7}, 300))
+	data := append([]byte{0, 1}, head...) // junk before the first page
+	data = append(data, music...)
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	for _, want := range [][]byte{head, music} {
+		page, err := readOggPage(r)
+		if err != nil || !bytes.Equal(page, want) {
+			t.Fatalf("readOggPage = %d bytes, err %v; want %d bytes", len(page), err, len(want))
+		}
+	}
+	if !oggHeaderPage(head) || oggHeaderPage(music) {
+		t.Error("oggHeaderPage does not tell the ID header from audio")
+	}
+}
+
+func TestFrameRingHeaders(t *testing.T) {
+	r := newFrameRing(4, 2)
+	r.pushHeader([]byte("head"))
+	r.pushHeader([]byte("tags"))
+	if h := r.headers(r.join()); len(h) != 0 {
+		t.Errorf("Reader at the start gets %d separate headers, want 0", len(h))
+	}
+	for i := range 6 {
+		r.push([]byte{byte(i)})
+	}
+	cursor := r.join()
+	h := r.headers(cursor)
+	if len(h) != 2 || string(h[0]) != "head" || string(h[1]) != "tags" {
+		t.Errorf("Late reader headers = %q, want head and tags", h)
+	}
+}
+
+func TestHTTPFormatErrors(t *testing.T) {
+	h := NewHTTPHandler(NewEncoders(NewBroadcaster()), HTTPConfig{Bitrate: 192}, nil)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	tests := []struct {
+		path, accept string
+		want         int
+	}{
+		{"/stream.ogg", "", http.StatusNotFound},
+		{"/stream?bitrate=100", "", http.StatusBadRequest}, // not an MP3 rate
+		{"/stream.opus?bitrate=1000", "", http.StatusBadRequest},
+		{"/stream.flac?bitrate=128", "", http.StatusBadRequest},
+		{"/stream", "text/html", http.StatusNotAcceptable},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("GET %s (Accept %q) = %d, want %d", tt.path, tt.accept, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestHTTPICYOnlyFramedFormats(t *testing.T) {
+	h := NewHTTPHandler(NewEncoders(NewBroadcaster()), HTTPConfig{Bitrate: 192}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // headers only: the encoder never starts
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/stream.flac", nil)
+	req.Header.Set("Icy-MetaData", "1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if ct, mi := rec.Header().Get("Content-Type"), rec.Header().Get("icy-metaint"); ct != "audio/flac" || mi != "" {
+		t.Errorf("FLAC with Icy-MetaData: Content-Type %q, icy-metaint %q; want audio/flac and no metadata", ct, mi)
+	}
+}
+
+func TestHTTPWAV(t *testing.T) {
+	b := NewBroadcaster()
+	source := make(chan []float32, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx, source)
+
+	srv := httptest.NewServer(NewHTTPHandler(NewEncoders(b), HTTPConfig{Bitrate: 192}, nil))
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/stream", nil)
+	req.Header.Set("Accept", "audio/wav")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "audio/wav" {
+		t.Fatalf("Content-Type = %q, want audio/wav", ct)
+	}
+	header := make([]byte, 44)
+	if _, err := io.ReadFull(resp.Body, header); err != nil {
+		t.Fatal(err)
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:16]) != "WAVEfmt " || binary.LittleEndian.Uint32(header[24:]) != audio.SampleRate {
+		t.Errorf("WAV header = % x", header)
+	}
+
+	frame := make([]float32, audio.FrameSamples)
+	frame[0] = 0.5
+	for b.ListenerCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	source <- frame
+	pcm := make([]byte, audio.FrameSamples*2)
+	if _, err := io.ReadFull(resp.Body, pcm); err != nil {
+		t.Fatal(err)
+	}
+	if s := int16(binary.LittleEndian.Uint16(pcm)); s != 16383 && s != 16384 {
+		t.Errorf("First sample = %d, want half scale", s)
+	}
+}
+
+// icecastStandIn is a minimal Icecast server: it accepts source
+// connections on one mount and records the audio and metadata updates.
+type icecastStandIn struct {
+	mount, auth string
+	protocol    string // method expected: PUT or SOURCE
+	sources     chan net.Conn
+	titles      chan string
+	attempts    atomic.Int32
+}
+
+func (s *icecastStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/admin/metadata" {
+		q := r.URL.Query()
+		if r.Header.Get("Authorization") != s.auth || q.Get("mount") != s.mount || q.Get("mode") != "updinfo" {
+			http.Error(w, "bad metadata request", http.StatusBadRequest)
+			return
+		}
+		s.titles <- q.Get("song")
+		return
+	}
+	s.attempts.Add(1)
+	if r.Method != s.protocol || r.URL.Path != s.mount || r.Header.Get("Content-Type") != "audio/mpeg" {
+		http.Error(w, "bad source request", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Authorization") != s.auth {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	conn, rw, _ := w.(http.Hijacker).Hijack()
+	if s.protocol == "PUT" {
+		rw.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+	} else {
+		rw.WriteString("HTTP/1.0 200 OK\r\n\r\n")
+	}
+	rw.Flush()
+	s.sources <- conn
+}
+
+func TestIcecastSource(t *testing.T) {
+	for _, protocol := range []string{"put", "source"} {
+		t.Run(protocol, func(t *testing.T) {
+			// Stand in for the MP3 encoder so no FFmpeg is needed. The extra
+			// reader keeps it from stopping between connections.
+			encoders := NewEncoders(NewBroadcaster())
+			enc := encoders.get(mp3Codec, 192)
+			ring := newFrameRing(1000, 10)
+			enc.ring, enc.cancel = ring, func() {}
+			enc.join()
+			frame := append(mp3Header(11, false), make([]byte, 572)...)
+
+			server := &icecastStandIn{
+				mount:    "/live.mp3",
+				auth:     "Basic c291cmNlOmhhY2ttZQ==", // source:hackme
+				protocol: strings.ToUpper(protocol),
+				sources:  make(chan net.Conn, 4),
+				titles:   make(chan string, 4),
+			}
+			srv := httptest.NewServer(server)
+			defer srv.Close()
+			cfg := IcecastConfig{URL: srv.URL, Mount: "/live.mp3", User: "source", Password: "hackme", Protocol: protocol, Format: "mp3", Bitrate: 192, Name: "infinara"}
+			src, err := NewIcecastSource(encoders, cfg, func() NowPlaying {
+				return NowPlaying{Title: "Night Drive", Genre: "synthwave"}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			src.backoffMin = 10 * time.Millisecond
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				for ctx.Err() == nil {
+					ring.push(frame)
+					time.Sleep(5 * time.Millisecond)
+				}
+			}()
+			go src.Run(ctx)
+
+			conn := <-server.sources
+			got := make([]byte, 4)
+			if _, err := io.ReadFull(conn, got); err != nil || !bytes.Equal(got, frame[:4]) {
+				t.Fatalf("Source sent % x, err %v; want an MP3 frame", got, err)
+			}
+			if !src.Connected() {
+				t.Error("Connected = false while streaming")
+			}
+			if title := <-server.titles; title != "Night Drive - synthwave" {
+				t.Errorf("Metadata title = %q", title)
+			}
+
+			// A dropped connection is reconnected, and the title sent again.
+			conn.Close()
+			select {
+			case conn = <-server.sources:
+				conn.Close()
+			case <-time.After(5 * time.Second):
+				t.Fatal("Source did not reconnect")
+			}
+			if title := <-server.titles; title != "Night Drive - synthwave" {
+				t.Errorf("Metadata title after reconnect = %q", title)
+			}
+		})
+	}
+}
+
+func TestIcecastSourceAuthFailure(t *testing.T) {
+	server := &icecastStandIn{mount: "/live.mp3", auth: "Basic c291cmNlOmhhY2ttZQ==", protocol: "PUT"}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	cfg := IcecastConfig{URL: srv.URL, Mount: "/live.mp3", User: "source", Password: "wrong", Protocol: "put", Format: "mp3"}
+	src, err := NewIcecastSource(NewEncoders(NewBroadcaster()), cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted, err := src.session(context.Background()); accepted || err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("session = %v, %v; want an authentication error", accepted, err)
+	}
+
+	// Rejected attempts back off: 10, 20, 40ms...
+	src.backoffMin, src.backoffMax = 10*time.Millisecond, 40*time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	server.attempts.Store(0)
+	src.Run(ctx)
+	if n := server.attempts.Load(); n < 3 || n > 8 {
+		t.Errorf("%d attempts in 200ms, want 3-8 with backoff", n)
+	}
+	if src.Connected() {
+		t.Error("Connected = true after failed attempts")
+	}
+}
+
+func TestNewIcecastSourceValidates(t *testing.T) {
+	good := IcecastConfig{URL: "http://icecast:8000", Mount: "/live", Protocol: "put", Format: "mp3"}
+	if src, err := NewIcecastSource(nil, good, nil); err != nil || src.cfg.Bitrate != 192 || src.host != "icecast:8000" {
+		t.Errorf("NewIcecastSource(%+v) = %v; want mp3 192k", good, err)
+	}
+	for _, mod := range []func(*IcecastConfig){
+		func(c *IcecastConfig) { c.URL = "icecast:8000" },
+		func(c *IcecastConfig) { c.Mount = "live" },
+		func(c *IcecastConfig) { c.Protocol = "rtmp" },
+		func(c *IcecastConfig) { c.Format = "flac" },
+		func(c *IcecastConfig) { c.Bitrate = 100 },
+	} {
+		cfg := good
+		mod(&cfg)
+		if _, err := NewIcecastSource(nil, cfg, nil); err == nil {
+			t.Errorf("NewIcecastSource(%+v) accepted", cfg)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/satindergrewal/infinara/internal/audio"
+)
+
+// EncoderMode selects how HTTP listeners are encoded.
+type EncoderMode string
+
+const (
+	EncoderShared      EncoderMode = "shared"       // one encoder per bitrate, shared through a ring buffer
+	EncoderPerListener EncoderMode = "per-listener" // one FFmpeg process per connection
+)
+
+// ParseEncoderMode validates an encoder mode name.
+func ParseEncoderMode(v string) (EncoderMode, error) {
+	switch m := EncoderMode(v); m {
+	case EncoderShared, EncoderPerListener:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown encoder mode %q (want shared or per-listener)", v)
+}
+
+const (
+	sharedRing  = 10 * time.Second // encoded audio kept for slow listeners
+	sharedBurst = 2 * time.Second  // buffered audio sent to a new listener
+)
+
+// codec describes an output encoding. One with readFrame splits into
+// self-contained frames, so it can be shared and cut at any frame.
+type codec struct {
+	name        string
+	ext         string // file extension for segments and mounts
+	contentType string
+	args        func(kbps int) []string // FFmpeg output arguments, nil for raw PCM
+	readFrame   func(*bufio.Reader) ([]byte, error)
+	header      func(frame []byte) bool // frames every reader needs first, if any
+	frameDur    time.Duration
+	kbps        int            // default bitrate
+	validKbps   func(int) bool // nil for lossless formats
+}
+
+// encodeArgs returns the FFmpeg arguments to encode the broadcast PCM,
+// read from stdin, with the given output arguments, written to stdout.
+func encodeArgs(output ...string) []string {
+	args := []string{
+		"-f", "s16le",
+		"-ar", strconv.Itoa(audio.SampleRate),
+		"-ac", strconv.Itoa(audio.Channels),
+		"-i", "pipe:0",
+	}
+	args = append(args, output...)
+	return append(args, "-fflags", "nobuffer", "-flush_packets", "1", "-loglevel", "error", "pipe:1")
+}
+
+// mp3Codec is MP3 with no ID3 or Xing header, so the output is nothing but
+// MPEG frames and a listener can start at any one of them.
+var mp3Codec = &codec{
+	name:        "mp3",
+	ext:         "mp3",
+	contentType: "audio/mpeg",
+	args: func(kbps int) []string {
+		return encodeArgs("-codec:a", "libmp3lame", "-b:a", strconv.Itoa(kbps)+"k",
+			"-f", "mp3", "-id3v2_version", "0", "-write_xing", "0")
+	},
+	readFrame: readMP3Frame,
+	frameDur:  mp3FrameDuration,
+	kbps:      192,
+	validKbps: ValidMP3Bitrate,
+}
+
+// aacCodec is AAC-LC in ADTS framing, where every frame carries its own
+// header.
+var aacCodec = &codec{
+	name:        "aac",
+	ext:         "aac",
+	contentType: "audio/aac",
+	args: func(kbps int) []string {
+		return encodeArgs("-codec:a", "aac", "-b:a", strconv.Itoa(kbps)+"k", "-f", "adts")
+	},
+	readFrame: readADTSFrame,
+	frameDur:  aacFrameDuration,
+	kbps:      128,
+	validKbps: func(kbps int) bool { return kbps >= 16 && kbps <= 320 },
+}
+
+// Frame lengths at the broadcast sample rate.
+const (
+	mp3FrameDuration = 1152 * time.Second / audio.SampleRate // MPEG-1 Layer III
+	aacFrameDuration = 1024 * time.Second / audio.SampleRate // AAC-LC
+)
+
+var (
+	mpeg1Bitrates = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mpeg2Bitrates = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	mpegRates     = [4]int{44100, 48000, 32000, 0}
+)
+
+// ValidMP3Bitrate reports whether kbps is an MPEG-1 Layer III bitrate.
+func ValidMP3Bitrate(kbps int) bool {
+	return kbps > 0 && slices.Contains(mpeg1Bitrates[:], kbps)
+}
+
+// mp3FrameLen returns the length in bytes of the Layer III frame whose
+// header is h (at least 4 bytes), or 0 if h is not a valid header.
+func mp3FrameLen(h []byte) int {
+	if h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return 0
+	}
+	version := h[1] >> 3 & 3 // 0: MPEG-2.5, 2: MPEG-2, 3: MPEG-1
+	layer := h[1] >> 1 & 3   // 1: Layer III
+	bitrate, rate := h[2]>>4, h[2]>>2&3
+	padding := int(h[2] >> 1 & 1)
+	if version == 1 || layer != 1 || bitrate == 0 || bitrate == 15 || rate == 3 {
+		return 0
+	}
+	if version == 3 {
+		return 144*mpeg1Bitrates[bitrate]*1000/mpegRates[rate] + padding
+	}
+	sr := mpegRates[rate] / 2
+	if version == 0 {
+		sr /= 2
+	}
+	return 72*mpeg2Bitrates[bitrate]*1000/sr + padding
+}
+
+// adtsFrameLen returns the length in bytes of the ADTS frame whose header
+// is h (at least 7 bytes), or 0 if h is not a valid header.
+func adtsFrameLen(h []byte) int {
+	if h[0] != 0xFF || h[1]&0xF6 != 0xF0 {
+		return 0
+	}
+	n := int(h[3]&3)<<11 | int(h[4])<<3 | int(h[5]>>5)
+	if n < 7 {
+		return 0
+	}
+	return n
+}
+
+// frameReader returns a function that reads the next frame, skipping
+// anything before a valid frame header of headerLen bytes.
+func frameReader(headerLen int, frameLen func([]byte) int) func(*bufio.Reader) ([]byte, error) {
+	return func(r *bufio.Reader) ([]byte, error) {
+		for {
+			h, err := r.Peek(headerLen)
+			if err != nil {
+				return nil, err
+			}
+			if n := frameLen(h); n > 0 {
+				frame := make([]byte, n)
+				_, err := io.ReadFull(r, frame)
+				return frame, err
+			}
+			r.Discard(1)
+		}
+	}
+}
+
+var (
+	readMP3Frame  = frameReader(4, mp3FrameLen)
+	readADTSFrame = frameReader(7, adtsFrameLen)
+)
+
+// frameRing holds the most recent encoded frames of one encoder run.
+// Readers keep a cursor (a frame sequence number) and catch up at their
+// own pace; one that falls out of the ring skips ahead.
+type frameRing struct {
+	mu     sync.Mutex
+	frames [][]byte // frame n is at frames[n%len(frames)]
+	first  int64    // oldest frame still held
+	next   int64    // sequence number of the next frame
+	burst  int      // frames a new reader starts behind the live edge
+	header [][]byte // the stream's header frames, frames 0 to len(header)-1
+	wake   chan struct{}
+	closed bool
+}
+
+func newFrameRing(size, burst int) *frameRing {
+	return &frameRing{frames: make([][]byte, size), burst: burst, wake: make(chan struct{})}
+}
+
+// push appends a frame and wakes waiting readers.
+func (r *frameRing) push(frame []byte) {
+	r.mu.Lock()
+	r.frames[r.next%int64(len(r.frames))] = frame
+	r.next++
+	r.first = max(r.first, r.next-int64(len(r.frames)))
+	close(r.wake)
+	r.wake = make(chan struct{})
+	r.mu.Unlock()
+}
+
+// pushHeader appends a header frame, which is also kept for readers that
+// join after it has left the ring.
+func (r *frameRing) pushHeader(frame []byte) {
+	r.mu.Lock()
+	r.header = append(r.header, frame)
+	r.mu.Unlock()
+	r.push(frame)
+}
+
+// headers returns the header frames a reader starting at cursor would
+// miss.
+func (r *frameRing) headers(cursor int64) [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.header[:min(int64(len(r.header)), cursor)]
+}
+
+// close ends the ring; readers get what is left, then stop.
+func (r *frameRing) close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.wake)
+	}
+	r.mu.Unlock()
+}
+
+// join returns the cursor for a new reader: the live edge less the burst.
+func (r *frameRing) join() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return max(r.first, r.next-int64(r.burst))
+}
+
+// read returns the frames from cursor up to the live edge, the cursor
+// after them, and a channel closed when more arrive. A cursor that fell
+// out of the ring rejoins as a new reader would (skipped reports how many
+// frames were lost). ok is false once the ring is closed and drained.
+func (r *frameRing) read(cursor int64) (frames [][]byte, next int64, skipped int64, wake <-chan struct{}, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cursor < r.first {
+		rejoin := max(r.first, r.next-int64(r.burst))
+		skipped, cursor = rejoin-cursor, rejoin
+	}
+	for n := cursor; n < r.next; n++ {
+		frames = append(frames, r.frames[n%int64(len(r.frames))])
+	}
+	return frames, r.next, skipped, r.wake, !r.closed || len(frames) > 0
+}
+
+// Encoders holds the shared encoders, one per codec and bitrate, so every
+// output that needs, say, 192k MP3 reads the same one.
+type Encoders struct {
+	broadcaster *Broadcaster
+	mu          sync.Mutex
+	encoders    map[string]*sharedEncoder
+}
+
+// NewEncoders creates an empty encoder set fed from b.
+func NewEncoders(b *Broadcaster) *Encoders {
+	return &Encoders{broadcaster: b, encoders: make(map[string]*sharedEncoder)}
+}
+
+// get returns the shared encoder for a codec and bitrate, creating it on
+// first use. It only runs while it has readers.
+func (s *Encoders) get(c *codec, kbps int) *sharedEncoder {
+	name := c.name + " " + strconv.Itoa(kbps) + "k"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.encoders[name]
+	if !ok {
+		e = &sharedEncoder{
+			broadcaster: s.broadcaster,
+			name:        name,
+			args:        c.args(kbps),
+			readFrame:   c.readFrame,
+			header:      c.header,
+			frameDur:    c.frameDur,
+		}
+		s.encoders[name] = e
+	}
+	return e
+}
+
+// sharedEncoder runs one FFmpeg encoder fed from the broadcaster while it
+// has readers, and shares its output through a frameRing.
+type sharedEncoder struct {
+	broadcaster *Broadcaster
+	name        string   // for logs, e.g. "mp3 192k"
+	args        []string // FFmpeg arguments, PCM on stdin, encoded on stdout
+	readFrame   func(*bufio.Reader) ([]byte, error)
+	header      func(frame []byte) bool
+	frameDur    time.Duration
+
+	mu      sync.Mutex
+	clients int
+	ring    *frameRing // current run, nil when stopped
+	cancel  context.CancelFunc
+}
+
+// join registers a listener, starting the encoder if needed, and returns
+// the ring to read and the cursor to start at.
+func (e *sharedEncoder) join() (*frameRing, int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ring == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		e.ring = newFrameRing(int(sharedRing/e.frameDur), int(sharedBurst/e.frameDur))
+		e.cancel = cancel
+		go e.run(ctx, e.ring)
+	}
+	e.clients++
+	return e.ring, e.ring.join()
+}
+
+// leave unregisters a listener, stopping the encoder after the last one.
+func (e *sharedEncoder) leave() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clients--
+	if e.clients == 0 && e.cancel != nil {
+		e.cancel()
+		e.ring, e.cancel = nil, nil
+	}
+}
+
+// copyTo joins the encoder at a frame boundary and writes its output to w:
+// the stream's header frames if any, a short burst of buffered audio, then
+// the live edge, calling flush after each batch. who names the reader in
+// logs. Returns nil when ctx is done, or the write error, or an error if
+// the encoder stops.
+func (e *sharedEncoder) copyTo(ctx context.Context, w io.Writer, flush func(), who string) error {
+	ring, cursor := e.join()
+	defer e.leave()
+
+	for _, f := range ring.headers(cursor) {
+		if _, err := w.Write(f); err != nil {
+			return err
+		}
+	}
+	for {
+		frames, next, skipped, wake, ok := ring.read(cursor)
+		if !ok {
+			return fmt.Errorf("encoder %s stopped", e.name)
+		}
+		if skipped > 0 {
+			log.Printf("%s fell behind, skipped %v", who, time.Duration(skipped)*e.frameDur)
+		}
+		for _, f := range frames {
+			if _, err := w.Write(f); err != nil {
+				return err
+			}
+		}
+		if len(frames) > 0 {
+			flush()
+		}
+		cursor = next
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		}
+	}
+}
+
+// run encodes broadcaster frames into ring until ctx is cancelled or
+// FFmpeg exits.
+func (e *sharedEncoder) run(ctx context.Context, ring *frameRing) {
+	defer func() {
+		ring.close()
+		e.mu.Lock()
+		if e.ring == ring { // FFmpeg died under its listeners: restart on the next join
+			e.cancel()
+			e.ring, e.cancel = nil, nil
+		}
+		e.mu.Unlock()
+	}()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", e.args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Printf("Shared encoder %s: stdin pipe error: %v", e.name, err)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Printf("Shared encoder %s: stdout pipe error: %v", e.name, err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("Shared encoder %s: ffmpeg start error: %v", e.name, err)
+		return
+	}
+	defer cmd.Wait()
+
+	tap := e.broadcaster.Tap()
+	defer e.broadcaster.Unsubscribe(tap)
+	log.Printf("Shared encoder %s started", e.name)
+	defer log.Printf("Shared encoder %s stopped", e.name)
+
+	go feed(ctx, tap, stdin)
+
+	r := bufio.NewReaderSize(stdout, 4096)
+	for {
+		frame, err := e.readFrame(r)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				log.Printf("Shared encoder %s: read error: %v", e.name, err)
+			}
+			return
+		}
+		if e.header != nil && e.header(frame) {
+			ring.pushHeader(frame)
+		} else {
+			ring.push(frame)
+		}
+	}
+}
+
+// feed writes a listener's PCM frames to an encoder's stdin until ctx is
+// cancelled or the listener is removed.
+func feed(ctx context.Context, l *Listener, stdin io.WriteCloser) {
+	defer stdin.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.done:
+			return
+		case frame, ok := <-l.C:
+			if !ok {
+				return
+			}
+			if _, err := stdin.Write(audio.SamplesToBytes(frame)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/satindergrewal/infinara/internal/audio"
+)
+
+// HTTP stream formats. MP3, AAC and Ogg/Opus are framed, so one encoder
+// per bitrate can be shared by every listener; FLAC gets an encoder per
+// connection, and WAV is the broadcast PCM as-is.
+
+// httpFormats are the formats served at /stream.<ext>, in order of
+// preference when negotiating.
+var httpFormats = []*codec{mp3Codec, aacCodec, opusCodec, flacCodec, wavCodec}
+
+// opusCodec is Opus in Ogg. Pages are cut every 100ms, a trade between
+// latency and page overhead at low bitrates. A listener joining mid-stream
+// gets the header pages first, then pages from the live edge.
+var opusCodec = &codec{
+	name:        "opus",
+	ext:         "opus",
+	contentType: "audio/ogg",
+	args: func(kbps int) []string {
+		return encodeArgs("-codec:a", "libopus", "-b:a", strconv.Itoa(kbps)+"k", "-f", "ogg", "-page_duration", "100000")
+	},
+	readFrame: readOggPage,
+	header:    oggHeaderPage,
+	frameDur:  oggPageDuration,
+	kbps:      64,
+	validKbps: func(kbps int) bool { return kbps >= 6 && kbps <= 510 },
+}
+
+// flacCodec is native FLAC. Its frames carry no length, so the stream
+// can't be cut between them and each listener gets its own encoder.
+var flacCodec = &codec{
+	name:        "flac",
+	ext:         "flac",
+	contentType: "audio/flac",
+	args: func(int) []string {
+		return encodeArgs("-codec:a", "flac", "-f", "flac")
+	},
+}
+
+// wavCodec is the broadcast PCM behind a WAV header, with no encoder.
+var wavCodec = &codec{
+	name:        "wav",
+	ext:         "wav",
+	contentType: "audio/wav",
+}
+
+const oggPageDuration = 100 * time.Millisecond
+
+// readOggPage reads the next Ogg page, skipping anything before a page
+// header.
+func readOggPage(r *bufio.Reader) ([]byte, error) {
+	for {
+		h, err := r.Peek(27)
+		if err != nil {
+			return nil, err
+		}
+		if string(h[:4]) != "OggS" || h[4] != 0 {
+			r.Discard(1)
+			continue
+		}
+		h, err = r.Peek(27 + int(h[26])) // header and segment table
+		if err != nil {
+			return nil, err
+		}
+		n := len(h)
+		for _, lacing := range h[27:] {
+			n += int(lacing)
+		}
+		page := make([]byte, n)
+		_, err = io.ReadFull(r, page)
+		return page, err
+	}
+}
+
+// oggHeaderPage reports whether page is a header page (ID or comment
+// header), which has granule position 0.
+func oggHeaderPage(page []byte) bool {
+	return binary.LittleEndian.Uint64(page[6:14]) == 0
+}
+
+// wavHeader returns a header for an endless 16-bit PCM WAV stream: the
+// RIFF and data sizes are set to the maximum, as for a file still being
+// written.
+func wavHeader() []byte {
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 0xFFFFFFFF)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(h[22:], audio.Channels)
+	binary.LittleEndian.PutUint32(h[24:], audio.SampleRate)
+	binary.LittleEndian.PutUint32(h[28:], audio.SampleRate*audio.Channels*2)
+	binary.LittleEndian.PutUint16(h[32:], audio.Channels*2)
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], 0xFFFFFFFF)
+	return h
+}
+
+// HTTPMounts returns the paths the HTTP stream is served at: /stream and
+// /stream.<ext> for each format.
+func HTTPMounts() []string {
+	mounts := []string{"/stream"}
+	for _, c := range httpFormats {
+		mounts = append(mounts, "/stream."+c.ext)
+	}
+	return mounts
+}
+
+// formatByExt returns the format served at /stream.<ext>, or nil.
+func formatByExt(ext string) *codec {
+	for _, c := range httpFormats {
+		if c.ext == ext {
+			return c
+		}
+	}
+	return nil
+}
+
+// negotiate picks the format for /stream from an Accept header. MP3 goes
+// to any client that accepts it, so browsers that list other types ahead
+// of audio/* still get the default; other clients get the format they
+// prefer most. Returns nil if none is acceptable.
+func negotiate(accept string) *codec {
+	if strings.TrimSpace(accept) == "" {
+		return mp3Codec
+	}
+	var best *codec
+	bestQ := 0.0
+	for _, c := range httpFormats {
+		q := acceptQuality(accept, c.contentType)
+		if c == mp3Codec && q > 0 {
+			return c
+		}
+		if q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality an Accept header gives contentType,
+// from its most specific matching media range.
+func acceptQuality(accept, contentType string) float64 {
+	typ, _, _ := strings.Cut(contentType, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch mediaRange {
+		case contentType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+	}
+	return q
+}
@@ -0,0 +1,353 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NowPlaying is the track metadata sent in-band to players.
+type NowPlaying struct {
+	Title string
+	Genre string
+}
+
+// HLSConfig configures the HLS output.
+type HLSConfig struct {
+	Codec   string        // "aac" or "mp3"
+	Bitrate int           // kbps
+	Segment time.Duration // target segment length
+	Window  int           // segments listed in the playlist
+}
+
+const (
+	hlsIdle     = 30 * time.Second // stop segmenting after this long without requests
+	hlsKeep     = 3                // segments kept past the window for clients mid-fetch
+	hlsClientTO = 3                // segment lengths without a request before a client is gone
+)
+
+// hlsSegment is one packed-audio segment: an ID3 tag carrying its
+// timestamp and the now-playing metadata, then whole codec frames.
+type hlsSegment struct {
+	seq      int64
+	disc     int64     // discontinuities before this segment
+	gap      bool      // frames were lost before this segment
+	start    time.Time // wall clock time of the first frame
+	duration time.Duration
+	meta     NowPlaying
+	data     []byte
+}
+
+// HLSHandler serves a live HLS stream at /hls/live.m3u8. A segmenter reads
+// one of the shared encoders and cuts its frames into segments, kept in a
+// sliding window in memory. It runs while clients are polling and stops
+// after hlsIdle without requests. A track change always starts a new
+// segment, so the title and EXT-X-PROGRAM-DATE-TIME of each segment mark
+// where it changed.
+type HLSHandler struct {
+	encoders   *Encoders
+	codec      *codec
+	cfg        HLSConfig
+	nowPlaying func() NowPlaying
+
+	mu          sync.Mutex
+	segments    []*hlsSegment
+	published   chan struct{} // closed and replaced when a segment is published
+	seq         int64         // next segment sequence number
+	disc        int64         // discontinuities so far
+	pts         time.Duration // stream time of the next frame, for ID3 timestamps
+	running     int           // generation of the running segmenter, 0 if stopped
+	generation  int
+	lastRequest time.Time
+	clients     map[string]time.Time // last request per client address
+}
+
+// NewHLSHandler creates an HLS handler. nowPlaying reports the track on
+// air; it may be nil.
+func NewHLSHandler(encoders *Encoders, cfg HLSConfig, nowPlaying func() NowPlaying) (*HLSHandler, error) {
+	var c *codec
+	switch cfg.Codec {
+	case "aac":
+		c = aacCodec
+	case "mp3":
+		c = mp3Codec
+		if !ValidMP3Bitrate(cfg.Bitrate) {
+			return nil, fmt.Errorf("invalid MP3 bitrate %dk", cfg.Bitrate)
+		}
+	default:
+		return nil, fmt.Errorf("unknown HLS codec %q (want aac or mp3)", cfg.Codec)
+	}
+	if cfg.Segment < time.Second || cfg.Window < 2 {
+		return nil, fmt.Errorf("HLS segments must be at least 1s with a window of 2 or more")
+	}
+	if nowPlaying == nil {
+		nowPlaying = func() NowPlaying { return NowPlaying{} }
+	}
+	return &HLSHandler{
+		encoders:   encoders,
+		codec:      c,
+		cfg:        cfg,
+		nowPlaying: nowPlaying,
+		published:  make(chan struct{}),
+		clients:    make(map[string]time.Time),
+	}, nil
+}
+
+// ListenerCount returns the number of clients that requested the playlist
+// or a segment within the last few segment lengths.
+func (h *HLSHandler) ListenerCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cutoff := time.Now().Add(-hlsClientTO * h.cfg.Segment)
+	for addr, t := range h.clients {
+		if t.Before(cutoff) {
+			delete(h.clients, addr)
+		}
+	}
+	return len(h.clients)
+}
+
+func (h *HLSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/hls/")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if name == "live.m3u8" {
+		h.touch(r.RemoteAddr)
+		h.servePlaylist(w, r)
+		return
+	}
+	seqStr, ok := strings.CutSuffix(name, "."+h.codec.ext)
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if !ok || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.touch(r.RemoteAddr)
+	seg := h.segment(seq)
+	if seg == nil {
+		http.Error(w, "segment expired", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", h.codec.contentType)
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.Write(seg.data)
+}
+
+// touch records a request, starting the segmenter if it is stopped.
+func (h *HLSHandler) touch(remoteAddr string) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	h.lastRequest = now
+	h.clients[host] = now
+	if h.running == 0 {
+		h.generation++
+		h.running = h.generation
+		go h.run(h.generation)
+	}
+}
+
+// segment returns a published segment by sequence number, or nil.
+func (h *HLSHandler) segment(seq int64) *hlsSegment {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, s := range h.segments {
+		if s.seq == seq {
+			return s
+		}
+	}
+	return nil
+}
+
+// servePlaylist writes the live playlist. Right after the segmenter starts
+// there are no segments yet, so it waits up to two segment lengths for
+// the first one.
+func (h *HLSHandler) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	empty, published := len(h.segments) == 0, h.published
+	h.mu.Unlock()
+	if empty {
+		select {
+		case <-published:
+		case <-time.After(2 * h.cfg.Segment):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(h.playlist())
+}
+
+// playlist renders the sliding window of segments.
+func (h *HLSHandler) playlist() []byte {
+	h.mu.Lock()
+	segs := slices.Clone(h.segments[max(0, len(h.segments)-h.cfg.Window):])
+	h.mu.Unlock()
+
+	frames := math.Ceil(float64(h.cfg.Segment) / float64(h.codec.frameDur))
+	target := int(math.Ceil((time.Duration(frames) * h.codec.frameDur).Seconds()))
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n", target)
+	if len(segs) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segs[0].seq)
+		if segs[0].disc > 0 {
+			fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", segs[0].disc)
+		}
+	}
+	for _, s := range segs {
+		if s.gap {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", s.start.UTC().Format("2006-01-02T15:04:05.000Z"))
+		fmt.Fprintf(&b, "#EXTINF:%.3f,%s\n", s.duration.Seconds(), s.meta.Title)
+		fmt.Fprintf(&b, "%d.%s\n", s.seq, h.codec.ext)
+	}
+	return b.Bytes()
+}
+
+// run segments the shared encoder's output until no client has made a
+// request for hlsIdle, or the encoder stops. If the segmenter falls out
+// of the ring, the open segment ends at the gap and the next one is
+// marked as a discontinuity, with its timestamp past the lost frames.
+func (h *HLSHandler) run(gen int) {
+	enc := h.encoders.get(h.codec, h.cfg.Bitrate)
+	ring, cursor := enc.join()
+	log.Printf("HLS segmenter started (%s)", enc.name)
+	defer func() {
+		enc.leave()
+		h.mu.Lock()
+		if h.running == gen {
+			h.running = 0
+			h.segments = nil
+		}
+		h.mu.Unlock()
+		log.Printf("HLS segmenter stopped")
+	}()
+
+	perSegment := int(math.Ceil(float64(h.cfg.Segment) / float64(h.codec.frameDur)))
+	idle := time.NewTicker(h.cfg.Segment)
+	defer idle.Stop()
+	var seg *hlsSegment
+	var nframes int
+	gap := false
+	for {
+		frames, next, skipped, wake, ok := ring.read(cursor)
+		if !ok {
+			return
+		}
+		if skipped > 0 {
+			if seg != nil {
+				h.publish(seg)
+				seg = nil
+			}
+			h.mu.Lock()
+			h.pts += time.Duration(skipped) * h.codec.frameDur
+			h.mu.Unlock()
+			gap = true
+		}
+		for i, f := range frames {
+			np := h.nowPlaying()
+			if seg != nil && (nframes >= perSegment || np != seg.meta) {
+				h.publish(seg)
+				seg = nil
+			}
+			if seg == nil {
+				// The frames of a burst were encoded before now.
+				behind := time.Duration(len(frames)-i-1) * h.codec.frameDur
+				seg = h.newSegment(time.Now().Add(-behind), np, gap)
+				nframes, gap = 0, false
+			}
+			seg.data = append(seg.data, f...)
+			seg.duration += h.codec.frameDur
+			nframes++
+			h.mu.Lock()
+			h.pts += h.codec.frameDur
+			h.mu.Unlock()
+		}
+		cursor = next
+		select {
+		case <-wake:
+		case <-idle.C:
+			h.mu.Lock()
+			stop := time.Since(h.lastRequest) > hlsIdle
+			h.mu.Unlock()
+			if stop {
+				return
+			}
+		}
+	}
+}
+
+// newSegment starts a segment with its ID3 tag. gap marks it as the
+// first after lost frames.
+func (h *HLSHandler) newSegment(start time.Time, np NowPlaying, gap bool) *hlsSegment {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seg := &hlsSegment{seq: h.seq, disc: h.disc, gap: gap, start: start, meta: np, data: id3Tag(h.pts, np)}
+	h.seq++
+	if gap {
+		h.disc++
+	}
+	return seg
+}
+
+// publish adds a finished segment to the window.
+func (h *HLSHandler) publish(seg *hlsSegment) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.segments = append(h.segments, seg)
+	if n := len(h.segments) - h.cfg.Window - hlsKeep; n > 0 {
+		h.segments = append(h.segments[:0], h.segments[n:]...)
+	}
+	close(h.published)
+	h.published = make(chan struct{})
+}
+
+// id3Tag builds the ID3v2.4 tag that starts a packed-audio segment: the
+// segment's timestamp in the PRIV frame HLS requires, plus the title
+// (TIT2) and genre (TCON) as timed metadata.
+func id3Tag(pts time.Duration, np NowPlaying) []byte {
+	var frames bytes.Buffer
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, uint64(pts/time.Second*90000+pts%time.Second*90000/time.Second)&(1<<33-1)) // 33-bit MPEG-2 timestamp at 90kHz, wrapping after 26.5h
+	id3Frame(&frames, "PRIV", append([]byte("com.apple.streaming.transportStreamTimestamp\x00"), ts...))
+	if np.Title != "" {
+		id3Frame(&frames, "TIT2", append([]byte{3}, np.Title...)) // 3: UTF-8
+	}
+	if np.Genre != "" {
+		id3Frame(&frames, "TCON", append([]byte{3}, np.Genre...))
+	}
+	tag := []byte{'I', 'D', '3', 4, 0, 0}
+	tag = append(tag, syncsafe(frames.Len())...)
+	return append(tag, frames.Bytes()...)
+}
+
+func id3Frame(b *bytes.Buffer, id string, data []byte) {
+	b.WriteString(id)
+	b.Write(syncsafe(len(data)))
+	b.Write([]byte{0, 0}) // flags
+	b.Write(data)
+}
+
+// syncsafe encodes n as an ID3 syncsafe integer: 7 bits per byte.
+func syncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
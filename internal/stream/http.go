@@ -2,23 +2,56 @@ package stream
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
 
 	"github.com/satindergrewal/infinara/internal/audio"
 )
 
-// HTTPHandler serves a chunked MP3 audio stream via HTTP.
-// Each connection spawns an FFmpeg process to encode PCM -> MP3 in real-time.
+// HTTPConfig configures the HTTP streams.
+type HTTPConfig struct {
+	Mode    EncoderMode
+	Bitrate int // default MP3 bitrate, kbps
+}
+
+// icyMetaInt is the number of audio bytes between ICY metadata blocks, the
+// Shoutcast default.
+const icyMetaInt = 16000
+
+// HTTPHandler serves chunked audio streams via HTTP: /stream.<ext> in each
+// of httpFormats, and /stream negotiated from the Accept header (MP3 by
+// default). ?bitrate= picks the bitrate of a lossy format. In shared mode
+// one encoder per format and bitrate serves every connection; in
+// per-listener mode each connection spawns its own FFmpeg process. MP3 and
+// AAC clients that send Icy-MetaData: 1 get the now-playing title
+// interleaved in the stream; the other formats are containers that can't
+// take it.
 type HTTPHandler struct {
-	broadcaster *Broadcaster
+	encoders   *Encoders
+	cfg        HTTPConfig
+	nowPlaying func() NowPlaying
+	listeners  atomic.Int32
+}
+
+// NewHTTPHandler creates an HTTP stream handler. nowPlaying reports the
+// track on air for ICY metadata; it may be nil.
+func NewHTTPHandler(encoders *Encoders, cfg HTTPConfig, nowPlaying func() NowPlaying) *HTTPHandler {
+	if nowPlaying == nil {
+		nowPlaying = func() NowPlaying { return NowPlaying{} }
+	}
+	return &HTTPHandler{encoders: encoders, cfg: cfg, nowPlaying: nowPlaying}
 }
 
-// NewHTTPHandler creates an HTTP stream handler.
-func NewHTTPHandler(b *Broadcaster) *HTTPHandler {
-	return &HTTPHandler{broadcaster: b}
+// ListenerCount returns the number of connected HTTP listeners.
+func (h *HTTPHandler) ListenerCount() int {
+	return int(h.listeners.Load())
 }
 
 func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -28,29 +61,148 @@ func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "audio/mpeg")
+	var c *codec
+	if ext, ok := strings.CutPrefix(r.URL.Path, "/stream."); ok {
+		if c = formatByExt(ext); c == nil {
+			http.NotFound(w, r)
+			return
+		}
+	} else {
+		w.Header().Set("Vary", "Accept")
+		if c = negotiate(r.Header.Get("Accept")); c == nil {
+			http.Error(w, "no acceptable format (mp3, aac, ogg, flac or wav)", http.StatusNotAcceptable)
+			return
+		}
+	}
+	kbps, err := h.bitrate(c, r.URL.Query().Get("bitrate"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", c.contentType)
 	w.Header().Set("Cache-Control", "no-cache, no-store")
 	w.Header().Set("Connection", "close")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("ICY-Name", "infinara")
+	var out io.Writer = w
+	if r.Header.Get("Icy-MetaData") == "1" && (c == mp3Codec || c == aacCodec) {
+		w.Header().Set("icy-metaint", strconv.Itoa(icyMetaInt))
+		out = &icyWriter{w: w, interval: icyMetaInt, remaining: icyMetaInt, nowPlaying: h.nowPlaying}
+	}
+
+	h.listeners.Add(1)
+	defer h.listeners.Add(-1)
+	log.Printf("HTTP listener connected (%s, total: %d)", c.name, h.ListenerCount())
+	defer log.Printf("HTTP listener disconnected")
+
+	switch {
+	case c.args == nil:
+		h.servePCM(r.Context(), out, flusher)
+	case c.readFrame == nil || h.cfg.Mode == EncoderPerListener:
+		h.servePerListener(r.Context(), c.args(kbps), out, flusher)
+	default:
+		h.serveShared(r.Context(), c, kbps, out, flusher)
+	}
+}
+
+// bitrate returns the bitrate to encode c at: v if set, else the default.
+func (h *HTTPHandler) bitrate(c *codec, v string) (int, error) {
+	if v == "" {
+		if c == mp3Codec {
+			return h.cfg.Bitrate, nil
+		}
+		return c.kbps, nil
+	}
+	if c.validKbps == nil {
+		return 0, fmt.Errorf("%s is lossless, it has no bitrate", c.name)
+	}
+	kbps, err := strconv.Atoi(strings.TrimSuffix(v, "k"))
+	if err != nil || !c.validKbps(kbps) {
+		return 0, fmt.Errorf("invalid %s bitrate %q", c.name, v)
+	}
+	return kbps, nil
+}
+
+// icyWriter interleaves ICY metadata with the audio: after every interval
+// bytes, a length byte n and n*16 bytes of StreamTitle='...';, zero
+// padded. The title is only sent when it changes; other blocks are empty.
+type icyWriter struct {
+	w          io.Writer
+	interval   int
+	remaining  int // audio bytes until the next metadata block
+	nowPlaying func() NowPlaying
+	sent       string
+}
+
+func (iw *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n, err := iw.w.Write(p[:min(len(p), iw.remaining)])
+		written += n
+		iw.remaining -= n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		if iw.remaining == 0 {
+			if _, err := iw.w.Write(iw.metadata()); err != nil {
+				return written, err
+			}
+			iw.remaining = iw.interval
+		}
+	}
+	return written, nil
+}
+
+// icyEscaper replaces the characters players take as the end of the
+// StreamTitle value.
+var icyEscaper = strings.NewReplacer("'", "’", ";", ",")
+
+// metadata returns the next metadata block.
+func (iw *icyWriter) metadata() []byte {
+	title := streamTitle(iw.nowPlaying())
+	if title == iw.sent {
+		return []byte{0}
+	}
+	iw.sent = title
+	title = icyEscaper.Replace(title)
+	if n := 255*16 - 15; len(title) > n { // 255 blocks at most
+		for !utf8.RuneStart(title[n]) {
+			n--
+		}
+		title = title[:n]
+	}
+	meta := "StreamTitle='" + title + "';"
+	block := make([]byte, 1+(len(meta)+15)/16*16)
+	block[0] = byte((len(meta) + 15) / 16)
+	copy(block[1:], meta)
+	return block
+}
 
-	ctx, cancel := context.WithCancel(r.Context())
+// streamTitle formats the now-playing track as "name - genre".
+func streamTitle(np NowPlaying) string {
+	switch {
+	case np.Title != "" && np.Genre != "":
+		return np.Title + " - " + np.Genre
+	case np.Title != "":
+		return np.Title
+	}
+	return np.Genre
+}
+
+// serveShared streams the shared encoder for c at kbps.
+func (h *HTTPHandler) serveShared(ctx context.Context, c *codec, kbps int, w io.Writer, flusher http.Flusher) {
+	h.encoders.get(c, kbps).copyTo(ctx, w, flusher.Flush, "HTTP listener")
+}
+
+// servePerListener encodes this connection with its own FFmpeg process:
+// PCM stdin -> encoded stdout.
+func (h *HTTPHandler) servePerListener(ctx context.Context, args []string, w io.Writer, flusher http.Flusher) {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	// FFmpeg: PCM stdin -> MP3 stdout
-	cmd := exec.CommandContext(ctx, "ffmpeg",
-		"-f", "s16le",
-		"-ar", "48000",
-		"-ac", "2",
-		"-i", "pipe:0",
-		"-codec:a", "libmp3lame",
-		"-b:a", "192k",
-		"-f", "mp3",
-		"-fflags", "nobuffer",
-		"-flush_packets", "1",
-		"-loglevel", "error",
-		"pipe:1",
-	)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -68,34 +220,13 @@ func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	listener := h.broadcaster.Subscribe()
-	defer h.broadcaster.Unsubscribe(listener)
-
-	log.Printf("HTTP listener connected (total: %d)", h.broadcaster.ListenerCount())
-	defer log.Printf("HTTP listener disconnected")
+	listener := h.encoders.broadcaster.Subscribe()
+	defer h.encoders.broadcaster.Unsubscribe(listener)
 
 	// Feed PCM frames to FFmpeg
-	go func() {
-		defer stdin.Close()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-listener.done:
-				return
-			case frame, ok := <-listener.C:
-				if !ok {
-					return
-				}
-				pcm := audio.SamplesToBytes(frame)
-				if _, err := stdin.Write(pcm); err != nil {
-					return
-				}
-			}
-		}
-	}()
+	go feed(ctx, listener, stdin)
 
-	// Read MP3 from FFmpeg and write to HTTP response
+	// Read from FFmpeg and write to HTTP response
 	buf := make([]byte, 4096)
 	for {
 		n, err := stdout.Read(buf)
@@ -115,3 +246,28 @@ func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	cmd.Wait()
 }
+
+// servePCM streams the broadcast PCM as WAV, with no encoder.
+func (h *HTTPHandler) servePCM(ctx context.Context, w io.Writer, flusher http.Flusher) {
+	listener := h.encoders.broadcaster.Subscribe()
+	defer h.encoders.broadcaster.Unsubscribe(listener)
+
+	if _, err := w.Write(wavHeader()); err != nil {
+		return
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case frame, ok := <-listener.C:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(audio.SamplesToBytes(frame)); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/satindergrewal/infinara/internal/audio"
+)
+
+// IcecastConfig configures publishing the station to an Icecast server.
+type IcecastConfig struct {
+	URL      string // server, e.g. http://icecast:8000
+	Mount    string // e.g. /infinara.mp3
+	User     string
+	Password string
+	Protocol string // "put" (Icecast 2.4+) or "source" (Icecast before 2.4)
+	Format   string // mp3, aac or opus
+	Bitrate  int    // kbps, 0 for the format's default
+	Name     string // station name for the directory listing
+	Public   bool   // list the stream in public directories
+}
+
+const (
+	icecastTimeout    = 10 * time.Second // connect, handshake and each write
+	icecastBackoffMin = time.Second
+	icecastBackoffMax = time.Minute
+)
+
+// IcecastSource is a source client: it pushes one of the shared encoders
+// to a mount on an Icecast server, reconnecting with exponential backoff
+// when the connection fails. For MP3 and AAC it updates the mount's title
+// through the admin API at each track change; Ogg streams carry their
+// titles in-stream, so Opus mounts show the station name only.
+type IcecastSource struct {
+	encoders   *Encoders
+	cfg        IcecastConfig
+	codec      *codec
+	host       string // host:port to dial
+	nowPlaying func() NowPlaying
+	connected  atomic.Bool
+
+	backoffMin, backoffMax time.Duration
+}
+
+// NewIcecastSource validates cfg and creates a source client. nowPlaying
+// reports the track on air; it may be nil.
+func NewIcecastSource(encoders *Encoders, cfg IcecastConfig, nowPlaying func() NowPlaying) (*IcecastSource, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Scheme != "http" || u.Host == "" {
+		return nil, fmt.Errorf("invalid Icecast URL %q (want http://host:port)", cfg.URL)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "8000")
+	}
+	if !strings.HasPrefix(cfg.Mount, "/") || len(cfg.Mount) < 2 {
+		return nil, fmt.Errorf("invalid Icecast mount %q (want /name)", cfg.Mount)
+	}
+	if cfg.Protocol != "put" && cfg.Protocol != "source" {
+		return nil, fmt.Errorf("unknown Icecast protocol %q (want put or source)", cfg.Protocol)
+	}
+	var c *codec
+	switch cfg.Format {
+	case "mp3":
+		c = mp3Codec
+	case "aac":
+		c = aacCodec
+	case "opus":
+		c = opusCodec
+	default:
+		return nil, fmt.Errorf("unknown Icecast format %q (want mp3, aac or opus)", cfg.Format)
+	}
+	if cfg.Bitrate == 0 {
+		cfg.Bitrate = c.kbps
+	} else if !c.validKbps(cfg.Bitrate) {
+		return nil, fmt.Errorf("invalid %s bitrate %dk", c.name, cfg.Bitrate)
+	}
+	if nowPlaying == nil {
+		nowPlaying = func() NowPlaying { return NowPlaying{} }
+	}
+	return &IcecastSource{
+		encoders:   encoders,
+		cfg:        cfg,
+		codec:      c,
+		host:       host,
+		nowPlaying: nowPlaying,
+		backoffMin: icecastBackoffMin,
+		backoffMax: icecastBackoffMax,
+	}, nil
+}
+
+// Connected reports whether the source is streaming to the server.
+func (s *IcecastSource) Connected() bool {
+	return s.connected.Load()
+}
+
+// Run streams to the server until ctx is cancelled, reconnecting after
+// failures. The backoff doubles from backoffMin to backoffMax and resets
+// once a connection is accepted.
+func (s *IcecastSource) Run(ctx context.Context) {
+	backoff := s.backoffMin
+	for {
+		accepted, err := s.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if accepted {
+			backoff = s.backoffMin
+		}
+		log.Printf("Icecast %s%s: %v (retrying in %v)", s.host, s.cfg.Mount, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, s.backoffMax)
+	}
+}
+
+// session makes one connection and streams until it fails. accepted
+// reports whether the server took the stream.
+func (s *IcecastSource) session(ctx context.Context) (accepted bool, err error) {
+	d := net.Dialer{Timeout: icecastTimeout}
+	conn, err := d.DialContext(ctx, "tcp", s.host)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })() // unblock a write on shutdown
+	if err := s.handshake(conn); err != nil {
+		return false, err
+	}
+	log.Printf("Icecast %s%s: streaming %s %dk", s.host, s.cfg.Mount, s.codec.name, s.cfg.Bitrate)
+	s.connected.Store(true)
+	defer s.connected.Store(false)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if s.codec.header == nil {
+		go s.pushMetadata(ctx)
+	}
+	w := &deadlineWriter{conn: conn, timeout: icecastTimeout}
+	if err := s.encoders.get(s.codec, s.cfg.Bitrate).copyTo(ctx, w, func() {}, "Icecast source"); err != nil {
+		return true, err
+	}
+	return true, ctx.Err()
+}
+
+// handshake sends the source request and waits for the server to accept
+// it. PUT is plain HTTP/1.1 with Expect: 100-continue; SOURCE is the
+// older HTTP/1.0-style request. Either way the audio follows on the same
+// connection with no framing.
+func (s *IcecastSource) handshake(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(icecastTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var b strings.Builder
+	if s.cfg.Protocol == "put" {
+		fmt.Fprintf(&b, "PUT %s HTTP/1.1\r\nHost: %s\r\nExpect: 100-continue\r\n", s.cfg.Mount, s.host)
+	} else {
+		fmt.Fprintf(&b, "SOURCE %s HTTP/1.0\r\n", s.cfg.Mount)
+	}
+	fmt.Fprintf(&b, "Authorization: %s\r\n", s.auth())
+	fmt.Fprintf(&b, "User-Agent: infinara\r\nContent-Type: %s\r\n", s.codec.contentType)
+	fmt.Fprintf(&b, "Ice-Name: %s\r\nIce-Public: %d\r\n", s.cfg.Name, boolInt(s.cfg.Public))
+	fmt.Fprintf(&b, "Ice-Audio-Info: bitrate=%d;samplerate=%d;channels=%d\r\n\r\n", s.cfg.Bitrate, audio.SampleRate, audio.Channels)
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusContinue, http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return fmt.Errorf("authentication failed for user %q", s.cfg.User)
+	}
+	return fmt.Errorf("server refused the stream: %s", resp.Status)
+}
+
+// pushMetadata sets the mount's title whenever the track on air changes,
+// until ctx is cancelled. A failed update is not retried until the next
+// track.
+func (s *IcecastSource) pushMetadata(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	sent := ""
+	for {
+		if title := streamTitle(s.nowPlaying()); title != "" && title != sent {
+			sent = title
+			if err := s.updateMetadata(ctx, title); err != nil && ctx.Err() == nil {
+				log.Printf("Icecast metadata update: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateMetadata sets the mount's title through the admin API.
+func (s *IcecastSource) updateMetadata(ctx context.Context, title string) error {
+	q := url.Values{"mount": {s.cfg.Mount}, "mode": {"updinfo"}, "song": {title}}
+	ctx, cancel := context.WithTimeout(ctx, icecastTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+s.host+"/admin/metadata?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", s.auth())
+	req.Header.Set("User-Agent", "infinara")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *IcecastSource) auth() string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(s.cfg.User+":"+s.cfg.Password))
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// deadlineWriter fails a write that takes longer than timeout, so a
+// stalled server is noticed and reconnected.
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.conn.Write(p)
+}